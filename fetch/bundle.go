@@ -0,0 +1,86 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+//
+// -----------------------------------------------------------------------------
+// BUNDLE
+// -----------------------------------------------------------------------------
+
+// Outputs written by the current run, by bundleName, collected for -bundle.
+// nil when -bundle isn't set.
+var bundled map[string][]byte
+
+// startBundle resets the collected outputs at the start of a pipeline run.
+func startBundle() {
+	if *bundle != "" {
+		bundled = map[string][]byte{}
+	}
+}
+
+// addToBundle records an output file for -bundle. Compressed copies aren't
+// recorded; the bundle is compressed as a whole.
+func addToBundle(path string, b []byte) {
+	if bundled != nil {
+		bundled[bundleName(path)] = b
+	}
+}
+
+// bundleName is the tar entry name for an output: its manifest name, so
+// states/CA.json keeps the path states.json links to. A file outside the
+// output directory is stored under its base name.
+func bundleName(path string) string {
+	if rel, ok := outputRel(path); ok {
+		return rel
+	}
+	return filepath.Base(path)
+}
+
+// writeBundle writes the collected outputs to path as a gzipped tar. Entries
+// are sorted by name and carry a fixed timestamp and owner, so the bundle is
+// byte-identical whenever its files are.
+func writeBundle(path string) error {
+	names := make([]string, 0, len(bundled))
+	for name := range bundled {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	tw := tar.NewWriter(zw)
+	for _, name := range names {
+		b := bundled[name]
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    int64(outputMode),
+			Size:    int64(len(b)),
+			ModTime: time.Unix(0, 0),
+			Format:  tar.FormatUSTAR,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	logInfo(fmt.Sprintf("Bundled %d files into %s.", len(names), path))
+	addToManifest(path, buf.Bytes())
+	return writeFile(path, buf.Bytes())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//
+// -----------------------------------------------------------------------------
+// DOWNLOAD CACHE
+// -----------------------------------------------------------------------------
+
+// validators are the HTTP cache validators of a cached cycle ZIP, stored next
+// to it as <zip>.json.
+type validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// cachePath returns where -cache-dir keeps the ZIP for cycle, under FAA's
+// file name.
+func cachePath(cycle time.Time) string {
+	url := formatZipURL(cycle)
+	return filepath.Join(*cacheDir, url[strings.LastIndex(url, "/")+1:])
+}
+
+// loadCached returns the cached ZIP for cycle and its validators, or nil when
+// there is no usable cache entry.
+func loadCached(cycle time.Time) ([]byte, validators) {
+	var v validators
+	if *cacheDir == "" {
+		return nil, v
+	}
+	path := cachePath(cycle)
+	meta, err := os.ReadFile(path + ".json")
+	if err != nil || json.Unmarshal(meta, &v) != nil || v == (validators{}) {
+		return nil, v
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, v
+	}
+	return b, v
+}
+
+// fetchCycle sends the GET for cycle, made conditional when -cache-dir holds a
+// previous download. A 304 returns the cached ZIP instead of a response; a
+// server that ignores the validators just answers 200 with the full file.
+func fetchCycle(cycle time.Time) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, formatZipURL(cycle), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cached, v := loadCached(cycle)
+	if cached != nil {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		logInfo("Cycle ZIP not modified since the cached download; reusing", cachePath(cycle))
+		return nil, cached, nil
+	}
+	return resp, nil, nil
+}
+
+// storeCached saves a validated download and the response's validators to
+// -cache-dir. Responses without validators aren't cached, since they could
+// never be revalidated. The cache is an optimization, so failures only warn.
+func storeCached(cycle time.Time, b []byte, h http.Header) {
+	if *cacheDir == "" {
+		return
+	}
+	v := validators{ETag: h.Get("ETag"), LastModified: h.Get("Last-Modified")}
+	if v == (validators{}) {
+		logDebug("Cycle ZIP response has no ETag or Last-Modified; not cached.")
+		return
+	}
+
+	meta, err := json.MarshalIndent(v, "", "  ")
+	path := cachePath(cycle)
+	if err == nil {
+		err = writeFile(path, b)
+	}
+	if err == nil {
+		err = writeFile(path+".json", meta)
+	}
+	if err != nil {
+		logWarn("Cannot update download cache:", err)
+	}
+}
+
+//
+// -----------------------------------------------------------------------------
+// PARSED DATASET CACHE
+// -----------------------------------------------------------------------------
+
+// parsedCache is a cycle's airports as buildAirports returned them, before any
+// correction, filter, or output option, so one cycle can be re-exported in
+// several formats without downloading or parsing it again.
+type parsedCache struct {
+	Cycle    string    `json:"cycle"` // EFF_DATE, for dataCycle
+	Options  string    `json:"options"`
+	Airports []Airport `json:"airports"`
+}
+
+// parsedPath returns where -cache-dir keeps the parsed dataset for cycle.
+func parsedPath(cycle time.Time) string {
+	return filepath.Join(*cacheDir, "parsed_"+cycle.Format("2006-01-02")+".json")
+}
+
+// parseOptions describes the flags that change what buildAirports returns or
+// whether it fails. A cached dataset built with other options is rebuilt
+// rather than reused, so -strict-coords checks every cycle it accepts.
+func parseOptions() string {
+	fuelMapSum := ""
+	if *fuelMap != "" {
+		b, _ := os.ReadFile(*fuelMap)
+		sum := sha256.Sum256(b)
+		fuelMapSum = hex.EncodeToString(sum[:8])
+	}
+	return fmt.Sprintf("allow-global=%t column=%q fix-swapped-coords=%t fuel-map=%s geocode=%t limit=%d strict-coords=%t",
+		*allowGlobal, *columns, *fixSwapped, fuelMapSum, *geocode, *limit, *strictCoords)
+}
+
+// useParsedCache reports whether this run may read a cached parsed dataset.
+// -refresh forces a rebuild, and -fuel-only, -rejects, and
+// -report-unknown-fuel need the ZIP's raw rows.
+func useParsedCache() bool {
+	return *cacheDir != "" && !*refresh && !*fuelOnly && *rejectsPath == "" && !*reportUnknownFuel
+}
+
+// loadParsed returns the cached airports for cycle and sets dataCycle, or
+// reports false when there is no cache entry built with the current options.
+func loadParsed(cycle time.Time) ([]Airport, bool) {
+	if !useParsedCache() {
+		return nil, false
+	}
+	b, err := os.ReadFile(parsedPath(cycle))
+	if err != nil {
+		return nil, false
+	}
+	var c parsedCache
+	if err := json.Unmarshal(b, &c); err != nil {
+		logWarn(fmt.Sprintf("Ignoring unreadable parsed-dataset cache %s: %v", parsedPath(cycle), err))
+		return nil, false
+	}
+	if c.Options != parseOptions() {
+		logInfo("Parsed-dataset cache was built with other options; rebuilding.")
+		return nil, false
+	}
+	dataCycle = c.Cycle
+	return c.Airports, true
+}
+
+// storeParsed saves airports as the parsed dataset for cycle. Runs on a
+// -zip archive have no cycle to key by and aren't cached. Like the download
+// cache, failures only warn.
+func storeParsed(cycle time.Time, airports []Airport) {
+	if *cacheDir == "" || cycle.IsZero() {
+		return
+	}
+	b, err := json.Marshal(parsedCache{Cycle: dataCycle, Options: parseOptions(), Airports: airports})
+	if err == nil {
+		err = writeFile(parsedPath(cycle), b)
+	}
+	if err != nil {
+		logWarn("Cannot update parsed-dataset cache:", err)
+	}
+}
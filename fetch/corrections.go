@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// FUEL CORRECTIONS
+// -----------------------------------------------------------------------------
+
+// fuelCorrection is one airport's entry in a -fuel-corrections file. Each
+// fuel is optional: a set value replaces FAA's flag, an absent one keeps it.
+// Source labels where the correction came from ("airnav", "pilot report").
+type fuelCorrection struct {
+	Avgas100LL *bool  `json:"100ll"`
+	JetA       *bool  `json:"jet_a"`
+	MoGas      *bool  `json:"mogas"`
+	Source     string `json:"source"`
+}
+
+// Default fuel_source values: NASR's flags, and a correction without its own
+// source label.
+const (
+	sourceFAA        = "faa"
+	sourceCorrection = "correction"
+)
+
+// loadFuelCorrections reads a JSON object of fuelCorrection keyed by LID or
+// ICAO. Keys are uppercased.
+func loadFuelCorrections(path string) (map[string]fuelCorrection, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]fuelCorrection
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	out := make(map[string]fuelCorrection, len(raw))
+	for id, c := range raw {
+		out[strings.ToUpper(strings.TrimSpace(id))] = c
+	}
+	return out, nil
+}
+
+// applyFuelCorrections overlays corrections onto the parsed fuel flags and
+// sets FuelSource on every airport: the correction's source where one
+// matched (by LID, else ICAO), otherwise "faa". Unmatched IDs are warned.
+func applyFuelCorrections(airports []Airport, corrections map[string]fuelCorrection) {
+	used := map[string]bool{}
+	for i := range airports {
+		ap := &airports[i]
+		ap.FuelSource = sourceFAA
+
+		id := ap.ArptID
+		c, ok := corrections[id]
+		if !ok && ap.ICAO != "" {
+			id = ap.ICAO
+			c, ok = corrections[id]
+		}
+		if !ok {
+			continue
+		}
+		used[id] = true
+
+		for _, o := range []struct{ from, to *bool }{
+			{c.Avgas100LL, &ap.Fuel.Avgas100LL},
+			{c.JetA, &ap.Fuel.JetA},
+			{c.MoGas, &ap.Fuel.MoGas},
+		} {
+			if o.from != nil {
+				*o.to = *o.from
+			}
+		}
+		ap.FuelSource = sourceCorrection
+		if c.Source != "" {
+			ap.FuelSource = c.Source
+		}
+	}
+
+	var unmatched []string
+	for id := range corrections {
+		if !used[id] {
+			unmatched = append(unmatched, id)
+		}
+	}
+	slices.Sort(unmatched)
+	logInfo(fmt.Sprintf("Applied fuel corrections to %d airports.", len(used)))
+	if len(unmatched) > 0 {
+		logWarn("Fuel corrections for unknown airports ignored: " + strings.Join(unmatched, ", "))
+	}
+}
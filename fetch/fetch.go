@@ -2,284 +2,3180 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
 )
 
-//
-// -----------------------------------------------------------------------------
-// TYPES
-// -----------------------------------------------------------------------------
+//
+// -----------------------------------------------------------------------------
+// TYPES
+// -----------------------------------------------------------------------------
+
+type Airport struct {
+	ArptID string  `json:"arpt_id"`
+	Name   string  `json:"name"`
+	City   string  `json:"city"`
+	State  string  `json:"state"`
+	ICAO   string  `json:"icao"`
+	Type   string  `json:"type,omitempty"`
+	Lat    float64 `json:"lat"`
+	Lon    float64 `json:"lon"`
+	Fuel   Fuel    `json:"fuel"`
+	CTAF   string  `json:"ctaf,omitempty"`
+
+	FuelBrand   string `json:"fuel_brand,omitempty"`
+	FuelRemarks string `json:"fuel_remarks,omitempty"`
+
+	// FuelSource says where Fuel came from ("faa", or a correction's source);
+	// it is only set with -fuel-corrections.
+	FuelSource string `json:"fuel_source,omitempty"`
+
+	LongestRunwayFt int    `json:"longest_runway_ft,omitempty"`
+	Manager         string `json:"manager,omitempty"`
+	ManagerPhone    string `json:"manager_phone,omitempty"`
+
+	Owner         string `json:"owner,omitempty"`
+	OwnershipType string `json:"ownership_type,omitempty"`
+
+	// Towered is nil when TWR_TYPE_CODE is blank or unrecognized, so an
+	// unknown tower status isn't reported as untowered.
+	Towered *bool `json:"towered,omitempty"`
+
+	// Region and District are the raw REGION_CODE (AWP) and ADO_CODE (SFO)
+	// of the FAA region and Airports District Office responsible for the
+	// airport.
+	Region   string `json:"faa_region,omitempty"`
+	District string `json:"faa_district,omitempty"`
+
+	// StateName is the full name of State, set by -state-names.
+	StateName string `json:"state_name,omitempty"`
+
+	// ApproxLocation marks coordinates estimated by -geocode.
+	ApproxLocation bool `json:"approx_location,omitempty"`
+
+	// NearestBase is the index in -bases of the closest base, and
+	// NearestBaseNM the distance to it; both are nil without -bases.
+	NearestBase   *int     `json:"nearest_base,omitempty"`
+	NearestBaseNM *float64 `json:"nearest_base_nm,omitempty"`
+}
+
+// Fuel has one flag per fuel type so every airport serializes with the same
+// keys. The JSON keys match fuelTypes and the keys earlier releases emitted.
+type Fuel struct {
+	Avgas100LL bool `json:"100ll"`
+	JetA       bool `json:"jet_a"`
+	MoGas      bool `json:"mogas"`
+}
+
+// Has reports the flag for a fuelTypes key.
+func (f Fuel) Has(key string) bool {
+	switch key {
+	case "mogas":
+		return f.MoGas
+	case "100ll":
+		return f.Avgas100LL
+	case "jet_a":
+		return f.JetA
+	}
+	return false
+}
+
+// set raises the flag for a fuelTypes key.
+func (f *Fuel) set(key string) {
+	switch key {
+	case "mogas":
+		f.MoGas = true
+	case "100ll":
+		f.Avgas100LL = true
+	case "jet_a":
+		f.JetA = true
+	}
+}
+
+// List returns the available fuels as fuelTypes keys, in fuelTypes order.
+func (f Fuel) List() []string {
+	out := []string{}
+	for _, key := range fuelTypes {
+		if f.Has(key) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// UnmarshalJSON accepts both the default object form and the -fuel-format
+// array form (["mogas", "jet_a"]), so datasets written either way load back.
+func (f *Fuel) UnmarshalJSON(b []byte) error {
+	var keys []string
+	if err := json.Unmarshal(b, &keys); err == nil {
+		*f = Fuel{}
+		for _, key := range keys {
+			f.set(key)
+		}
+		return nil
+	}
+	type plain Fuel // without this method
+	return json.Unmarshal(b, (*plain)(f))
+}
+
+// Any reports whether any fuel is available.
+func (f Fuel) Any() bool {
+	return f.MoGas || f.Avgas100LL || f.JetA
+}
+
+// Meta is written to meta.json next to the dataset.
+type Meta struct {
+	Generated time.Time `json:"generated"`
+	Cycle     string    `json:"cycle,omitempty"` // NASR effective date, YYYY-MM-DD
+	Count     int       `json:"count"`
+	Bounds    *Bounds   `json:"bounds,omitempty"`
+
+	// Partial is set when -partial-ok kept the airports read before the
+	// airport table failed to load.
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Bounds is the geographic extent of the dataset, e.g. for a map viewport.
+type Bounds struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// StateSummary counts one state's airports in total and per fuelTypes key.
+type StateSummary struct {
+	Airports int            `json:"airports"`
+	Fuel     map[string]int `json:"fuel"`
+}
+
+// Changes describes how the dataset differs from the previous run.
+type Changes struct {
+	Generated   time.Time    `json:"generated"`
+	Added       []string     `json:"added"`
+	Removed     []string     `json:"removed"`
+	FuelChanged []FuelChange `json:"fuel_changed"`
+}
+
+type FuelChange struct {
+	ArptID string `json:"arpt_id"`
+	Before Fuel   `json:"before"`
+	After  Fuel   `json:"after"`
+}
+
+// AirportDelta is one -changed-only record: the airport (as it was, for
+// removed ones) and how it changed. FuelBefore is set for fuel_changed.
+type AirportDelta struct {
+	Change string `json:"change"` // added, removed, or fuel_changed
+	Airport
+	FuelBefore *Fuel `json:"fuel_before,omitempty"`
+}
+
+// CycleDelta is the -since-cycle output: the changes that advance a dataset
+// from FromCycle to ToCycle.
+type CycleDelta struct {
+	FromCycle string         `json:"from_cycle"`
+	ToCycle   string         `json:"to_cycle"`
+	Airports  []AirportDelta `json:"airports"`
+}
+
+type namedCycle struct {
+	name string
+	date time.Time
+}
+
+//
+// -----------------------------------------------------------------------------
+// CONSTANTS
+// -----------------------------------------------------------------------------
+
+// FAA NASR known anchor cycle date
+// This corresponds to: 25_Dec_2025_APT_CSV.zip
+var anchorDate = time.Date(2025, 12, 25, 0, 0, 0, 0, time.UTC)
+
+const cycleLengthDays = 28
+
+// Working file for the downloaded cycle ZIP; always removed on exit.
+const zipPath = "cycle.zip"
+
+// Extract+parse attempts before giving up, to ride out transient IO errors.
+const maxParseAttempts = 2
+
+// SITE_TYPE_CODE values mapped to Airport.Type. Unknown codes pass through.
+var siteTypes = map[string]string{
+	"A": "airport",
+	"B": "balloonport",
+	"C": "seaplane_base",
+	"G": "gliderport",
+	"H": "heliport",
+	"U": "ultralight",
+}
+
+// FAA regions by REGION_CODE, for checking -regions.
+var faaRegions = map[string]string{
+	"AAL": "Alaskan",
+	"ACE": "Central",
+	"AEA": "Eastern",
+	"AGL": "Great Lakes",
+	"ANE": "New England",
+	"ANM": "Northwest Mountain",
+	"ASO": "Southern",
+	"ASW": "Southwest",
+	"AWP": "Western-Pacific",
+}
+
+// Lat/lon boxes around U.S. soil, with a small margin; coordinates outside
+// all of them are taken to be parse errors unless -allow-global is set. The
+// contiguous-states box still takes in the Canadian and Mexican border
+// strips, but not Canada north of 49.5° or Mexico south of 24°.
+var usRegions = []struct{ minLat, maxLat, minLon, maxLon float64 }{
+	{24, 49.5, -125, -66.5},      // contiguous states
+	{51, 72, -180, -141},         // Alaska west of the Yukon border
+	{54.5, 60.5, -141, -129.9},   // Alaska panhandle
+	{51, 54, 172, 180},           // Aleutians west of the antimeridian
+	{18.5, 22.5, -160.5, -154.5}, // Hawaii
+	{23, 29, -178.5, -161},       // Northwestern Hawaiian Islands, Midway
+	{17.5, 18.7, -68, -64.5},     // Puerto Rico, U.S. Virgin Islands
+	{13, 21, 144, 147},           // Guam, Northern Mariana Islands
+	{19, 20, 166, 167},           // Wake Island
+	{-15, -14, -172, -169},       // American Samoa
+}
+
+// OWNERSHIP_TYPE_CODE values in APT_BASE.csv. The military services all
+// normalize to "military"; anything else is left empty.
+var ownershipTypes = map[string]string{
+	"PU": "public",
+	"PR": "private",
+	"MA": "military", // Air Force
+	"MN": "military", // Navy
+	"MR": "military", // Army
+	"CG": "military", // Coast Guard
+}
+
+// Fuel brands recognized in (uppercased) fuel remarks.
+var fuelBrandPattern = regexp.MustCompile(`\b(PHILLIPS 66|WORLD FUEL|AIR BP|AVFUEL|CHEVRON|EXXON|SHELL|TITAN|EPIC|SINCLAIR|TEXACO|BP)\b`)
+
+// Fuel keys emitted in Airport.Fuel, in canonical order.
+var fuelTypes = []string{"mogas", "100ll", "jet_a"}
+
+//
+// -----------------------------------------------------------------------------
+// FLAGS
+// -----------------------------------------------------------------------------
+
+var outPath = flag.String("out", "public/airports.json", "dataset path or s3://bucket/key; other outputs are written alongside it")
+
+var outputDir = flag.String("output-dir", "", "write the dataset and every companion file under this directory or s3:// prefix; -out still overrides the dataset path")
+
+var fileMode = flag.String("file-mode", "0644", "octal permissions for written files, e.g. 0664 for a shared volume")
+
+var verbose = flag.Bool("verbose", false, "print debug output such as resolved CSV column indices")
+
+var quiet = flag.Bool("quiet", false, "suppress informational output; only warnings and errors are printed")
+
+var splitByFuel = flag.Bool("split-by-fuel", false, "also write public/<fuel>.json per fuel type")
+
+var splitByState = flag.Bool("split-by-state", false, "also write public/states/<ST>.json per state and a public/states.json index of them")
+
+var refresh = flag.Bool("refresh", false, "with -cache-dir, rebuild the cycle from its ZIP even when a parsed dataset is cached")
+
+var cacheDir = flag.String("cache-dir", "", "keep downloaded cycle ZIPs here and re-fetch them with If-None-Match/If-Modified-Since; a 304 reuses the cached copy. The parsed dataset is cached per cycle too")
+
+var keepZip = flag.String("keep-zip", "", "archive each downloaded cycle ZIP in this directory (or s3:// prefix), named with its cycle date")
+
+var zipFile = flag.String("zip", "", "use this local cycle ZIP instead of downloading; - reads it from stdin")
+
+var formatIn = flag.String("format-in", "csv", "layout of the -zip archive: csv (APT_BASE.csv) or fixed (the pre-CSV fixed-width APT.txt)")
+
+var prefer = flag.String("prefer", "next", "cycle to try first: next or current")
+
+var retryCycles = flag.Int("retry-cycles", 2, "number of cycles to try, newest first: 2 is next then current, 3 adds the one before, ...")
+
+var insecureSkipVerify = flag.Bool("insecure-skip-verify", false, "don't verify the FAA server's TLS certificate (for intercepting corporate proxies)")
+
+var noFallback = flag.Bool("no-fallback", false, "fail if the -prefer cycle is unavailable instead of falling back to the other one")
+
+var fuelFilter = flag.String("fuel", "", "comma-separated fuels; keep only airports offering any of them, e.g. mogas or mogas,100ll")
+
+var excludeFuel = flag.String("exclude-fuel", "", "comma-separated fuels; drop airports offering any of them, e.g. jet_a (applied after -fuel)")
+
+var regions = flag.String("regions", "", "comma-separated FAA region codes to keep, e.g. AWP,ANM (default all)")
+
+var types = flag.String("types", "", "comma-separated facility types to keep, e.g. airport,seaplane_base (default all)")
+
+var heatmap = flag.Bool("heatmap", false, "also write public/heatmap.json with airport and fuel counts per lat/lon grid cell")
+
+var heatmapCell = flag.Float64("heatmap-cell", 0.5, "-heatmap cell size in degrees")
+
+var summary = flag.Bool("summary", false, "also write public/summary.json with airport and fuel counts per state")
+
+var reportUnknownFuel = flag.Bool("report-unknown-fuel", false, "parse FUEL_TYPES, print the codes no fuel keyword matches by frequency, and exit without writing output")
+
+var partialOK = flag.Bool("partial-ok", false, "if the airport table fails to read partway through, write the airports read before the error instead of failing (meta.json gets \"partial\": true)")
+
+var countOnly = flag.Bool("count-only", false, "download and parse, print airport and fuel totals, and exit without writing output")
+
+var byICAO = flag.Bool("by-icao", false, "also write public/airports_by_icao.json keyed by ICAO and LID")
+
+var rename = flag.String("rename", "", "comma-separated old:new pairs renaming top-level JSON keys, e.g. lat:latitude,lon:longitude")
+
+var columns = flag.String("column", "", "comma-separated [TABLE:]COLUMN=INDEX pairs forcing a CSV column's index, e.g. APT_BASE.csv:FUEL_TYPES=18")
+
+var titleCaseNames = flag.Bool("title-case", false, "convert the all-caps name and city to title case (PALO ALTO -> Palo Alto)")
+
+var withStateNames = flag.Bool("state-names", false, "add state_name (e.g. California) next to each airport's two-letter state")
+
+var fields = flag.String("fields", "", "comma-separated JSON keys to emit per airport, e.g. arpt_id,icao,lat,lon,fuel (default all)")
+
+var emitTypesLang = flag.String("emit-types", "", "print type definitions for the dataset and exit: ts or json-schema")
+
+var airportID = flag.String("airport", "", "print the record for one LID or ICAO from the existing dataset and exit")
+
+var sinceCycle = flag.String("since-cycle", "", "write only the changes from this previous cycle's dataset, stamped with both cycle dates (to cycle_delta.json unless -out is set)")
+
+var changedOnly = flag.String("changed-only", "", "write only airports added, removed, or with changed fuel since this previous dataset (to delta.json unless -out is set)")
+
+var writeChanges = flag.Bool("changes", false, "write public/changes.json describing differences from the previous dataset")
+
+var verifyOurAirports = flag.Bool("verify-ourairports", false, "cross-check the parsed airport count and IDs against OurAirports and warn on large discrepancies")
+
+var ourAirportsSrc = flag.String("ourairports", ourAirportsURL, "OurAirports airports.csv URL or local path for -verify-ourairports")
+
+var selfTest = flag.Bool("selftest", false, "download, parse, and validate the current data end-to-end; print PASS/FAIL and write nothing")
+
+var home = flag.String("home", "", "sort the dataset by distance from lat,lon and add distance_nm to each airport")
+
+var near = flag.String("near", "", "print existing airports nearest to lat,lon as JSON and exit")
+
+var bases = flag.String("bases", "", "semicolon-separated lat,lon home bases; adds nearest_base (index) and nearest_base_nm to each airport")
+
+var unit = flag.String("unit", "nm", "distance unit for -near and -home: nm, sm, or km")
+
+var search = flag.String("search", "", "print existing airports whose name or city contains this text as JSON and exit")
+
+var maxResults = flag.Int("max-results", 10, "number of -near results per page")
+
+var offset = flag.Int("offset", 0, "number of -near results to skip, for paging")
+
+var fuelFormat = flag.String("fuel-format", "map", "how fuel is serialized in JSON: map ({\"mogas\": true, ...}) or array ([\"mogas\", \"jet_a\"], available fuels only)")
+
+var flattenFuel = flag.Bool("flatten-fuel", false, "in -format csv, write one pipe-delimited fuel column (MOGAS|100LL) instead of a true/false column per fuel")
+
+var format = flag.String("format", "json", "output format: json, overlay, kml, csv, or any other registered writer")
+
+var tilesDir = flag.String("tiles", "", "also write z/x/y.geojson point tiles of the dataset under this directory or s3:// prefix")
+
+var tileMaxZoom = flag.Int("tile-max-zoom", 8, "highest zoom level written by -tiles")
+
+var manifest = flag.Bool("manifest", false, "also write public/manifest.json listing every output file with its size and SHA-256")
+
+var bundle = flag.String("bundle", "", "also package every output file written by the run into this .tar.gz")
+
+var compress = flag.String("compress", "", "also write a compressed copy of each output: gzip (.gz) or br (.br)")
+
+var minRunway = flag.Int("min-runway", 0, "keep only airports whose longest runway is at least this many feet")
+
+var unknownRunway = flag.Bool("unknown-runway", false, "with -min-runway, also keep airports whose runway length is unknown")
+
+var towered = flag.String("towered", "", "keep only towered (yes) or untowered (no) airports; unknown tower status is dropped")
+
+var onlyWithICAO = flag.Bool("only-with-icao", false, "drop airports that have no ICAO identifier")
+
+var dedupeByICAO = flag.Bool("dedupe-by-icao", false, "when airports share an ICAO code, keep one each instead of only reporting the collision")
+
+var includeIDs = flag.String("include-ids", "", "file of LIDs/ICAOs (one per line); only these airports are written")
+
+var rejectsPath = flag.String("rejects", "", "write skipped APT_BASE.csv rows and the reason for each to this .csv or .json file")
+
+var allowGlobal = flag.Bool("allow-global", false, "keep airports whose coordinates fall outside the U.S. and its territories instead of dropping them")
+
+var strictCoords = flag.Bool("strict-coords", false, "fail with a report if any airport has missing, zero, invalid, swapped, or out-of-bounds coordinates instead of skipping it")
+
+var fixSwapped = flag.Bool("fix-swapped-coords", false, "swap back coordinates whose latitude is out of range but valid as a longitude, with a warning")
+
+var geocode = flag.Bool("geocode", false, "approximate missing coordinates from city/state instead of skipping the airport")
+
+var limit = flag.Int("limit", 0, "stop parsing after N airports (applied before filters); 0 means no limit")
+
+var strict = flag.Bool("strict", false, "fail instead of warning when the parsed data looks wrong")
+
+var inMemory = flag.Bool("in-memory", false, "keep the ZIP and CSVs in memory instead of writing cycle.zip and extracted files")
+
+var validateURL = flag.Bool("validate-url", false, "send a HEAD request before downloading; a 404 falls back without a GET")
+
+var serve = flag.String("serve", "", "serve the dataset and web UI on this address, e.g. :8080; with -watch, new cycles are hot-reloaded")
+
+var maxAge = flag.Duration("max-age", 0, "fail without writing when the data's effective date is older than this, e.g. 1344h (two cycles); 0 disables")
+
+var watch = flag.Duration("watch", 0, "keep running and rebuild every interval, e.g. 24h; runs are skipped while the cycle is unchanged")
+
+var fuelMap = flag.String("fuel-map", "", "file of \"CODE fuel\" lines overriding the built-in FUEL_TYPES keywords")
+
+var writeStatus = flag.Bool("status", false, "keep public/status.json updated with the current pipeline phase for UIs to poll")
+
+var fuelCorrections = flag.String("fuel-corrections", "", "JSON of per-airport fuel corrections, keyed by LID or ICAO, overriding FAA's fuel flags")
+
+var fuelOnly = flag.Bool("fuel-only", false, "only refresh fuel flags in the existing dataset (full rebuild if IDs don't align)")
+
+// Checks given with -assert (repeatable); see checkAssertions.
+var assertions []fuelAssertion
+
+func init() {
+	flag.Func("assert", "ID:fuel that must hold in the built dataset, e.g. KPAO:mogas; repeat for more checks", addAssertions)
+}
+
+// Fuels chosen with -fuel; nil keeps every airport.
+var wantFuels []string
+
+// Fuels chosen with -exclude-fuel; nil drops none.
+var excludeFuels []string
+
+// Region codes chosen with -regions; nil keeps every airport.
+var wantRegions []string
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+//
+// -----------------------------------------------------------------------------
+// LOGGING
+// -----------------------------------------------------------------------------
+
+func logInfo(a ...any) {
+	if *quiet {
+		return
+	}
+	fmt.Println(append([]any{"[INFO]"}, a...)...)
+}
+
+func logDebug(a ...any) {
+	if !*verbose || *quiet {
+		return
+	}
+	fmt.Println(append([]any{"[DEBUG]"}, a...)...)
+}
+
+func logWarn(a ...any) {
+	fmt.Fprintln(os.Stderr, append([]any{"[WARN]"}, a...)...)
+}
+
+func logError(a ...any) {
+	fmt.Fprintln(os.Stderr, append([]any{"[ERROR]"}, a...)...)
+}
+
+//
+// -----------------------------------------------------------------------------
+// MAIN ENTRY
+// -----------------------------------------------------------------------------
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		logError(err)
+		os.Exit(1)
+	}
+}
+
+// run validates flags and dispatches to the selected mode.
+func run() error {
+	w, ok := writers[*format]
+	if !ok {
+		return fmt.Errorf("invalid -format %q (want %s)", *format, strings.Join(writerNames(), ", "))
+	}
+	if *outputDir != "" && !flagSet("out") {
+		*outPath = siblingPath("airports.json")
+	}
+	if w.file != "" && !flagSet("out") {
+		// Don't replace the web UI's airports.json with a different shape.
+		*outPath = siblingPath(w.file)
+	}
+	if *changedOnly != "" && !flagSet("out") {
+		*outPath = siblingPath("delta.json")
+	}
+	if *sinceCycle != "" && *changedOnly != "" {
+		return errors.New("-since-cycle and -changed-only can't be combined")
+	}
+	if *sinceCycle != "" && !flagSet("out") {
+		*outPath = siblingPath("cycle_delta.json")
+	}
+	switch *compress {
+	case "", "gzip", "br":
+	default:
+		return fmt.Errorf("invalid -compress %q (want gzip or br)", *compress)
+	}
+	if *insecureSkipVerify {
+		logWarn("TLS certificate verification is disabled (-insecure-skip-verify); downloads can be tampered with.")
+		httpClient = newHTTPClient(true)
+	}
+	if strings.Contains(*cacheDir, "://") {
+		return fmt.Errorf("-cache-dir must be a local directory")
+	}
+	if *heatmapCell <= 0 || *heatmapCell > 90 {
+		return fmt.Errorf("-heatmap-cell must be greater than 0 and at most 90 degrees")
+	}
+	if *refresh && *cacheDir == "" {
+		return errors.New("-refresh only applies with -cache-dir")
+	}
+	if *tileMaxZoom < 0 || *tileMaxZoom > 16 {
+		return fmt.Errorf("-tile-max-zoom must be between 0 and 16")
+	}
+	if *retryCycles < 1 {
+		return fmt.Errorf("-retry-cycles must be at least 1")
+	}
+	if _, ok := unitsPerNM[*unit]; !ok {
+		return fmt.Errorf("invalid -unit %q (want nm, sm, or km)", *unit)
+	}
+	if *home != "" {
+		var err error
+		homeLat, homeLon, err = parseLatLon(*home)
+		if err != nil {
+			return fmt.Errorf("-home: %w", err)
+		}
+	}
+	if *bases != "" {
+		var err error
+		homeBases, err = parseBases(*bases)
+		if err != nil {
+			return fmt.Errorf("-bases: %w", err)
+		}
+	}
+	mode, err := strconv.ParseUint(*fileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return fmt.Errorf("invalid -file-mode %q (want octal permissions such as 0644)", *fileMode)
+	}
+	outputMode = os.FileMode(mode)
+	switch *formatIn {
+	case "csv":
+	case "fixed":
+		if *zipFile == "" {
+			return errors.New("-format-in fixed reads archival ZIPs; give one with -zip")
+		}
+	default:
+		return fmt.Errorf("invalid -format-in %q (want csv or fixed)", *formatIn)
+	}
+	switch *fuelFormat {
+	case "map", "array":
+	default:
+		return fmt.Errorf("invalid -fuel-format %q (want map or array)", *fuelFormat)
+	}
+	switch *towered {
+	case "", "yes", "no":
+	default:
+		return fmt.Errorf("invalid -towered %q (want yes or no)", *towered)
+	}
+	if *regions != "" {
+		wantRegions = parseRegions(*regions)
+	}
+	if *fuelFilter != "" {
+		wantFuels, err = parseFuelFilter(*fuelFilter)
+		if err != nil {
+			return err
+		}
+	}
+	if *excludeFuel != "" {
+		excludeFuels, err = parseFuelFilter(*excludeFuel)
+		if err != nil {
+			return fmt.Errorf("-exclude-fuel: %w", err)
+		}
+		for _, f := range excludeFuels {
+			if slices.Contains(wantFuels, f) {
+				return fmt.Errorf("%s is in both -fuel and -exclude-fuel, which would drop every airport", f)
+			}
+		}
+	}
+	if *columns != "" {
+		columnOverrides, err = parseColumnOverrides(*columns)
+		if err != nil {
+			return err
+		}
+	}
+	if *fuelMap != "" {
+		err = loadFuelKeywords(*fuelMap)
+		if err != nil {
+			return err
+		}
+	}
+	if *fields != "" {
+		selectedFields, err = parseFields(*fields)
+		if err != nil {
+			return err
+		}
+	}
+	if *rename != "" {
+		renames, err = parseRenames(*rename)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *emitTypesLang != "" {
+		return emitTypes(*emitTypesLang)
+	}
+	if *airportID != "" {
+		return lookupAirport(*airportID)
+	}
+	if *near != "" {
+		return queryNear(*near)
+	}
+	if *search != "" {
+		return searchAirports(*search)
+	}
+	if *selfTest {
+		return runSelfTest()
+	}
+	if *zipFile != "" {
+		return updateFromZip(*zipFile)
+	}
+	if *serve != "" {
+		return runServer()
+	}
+	if *watch > 0 {
+		return runWatch(nil)
+	}
+
+	return update()
+}
+
+// update downloads the cycle ZIP and runs the pipeline. The ZIP and extracted
+// CSVs are removed on every exit path, including panics.
+func update() error {
+	_, err := updateFrom(time.Time{})
+	return err
+}
+
+// updateFromZip runs the pipeline on a local cycle ZIP, or on one read from
+// stdin when path is "-", instead of downloading.
+func updateFromZip(path string) error {
+	startStatus()
+
+	var zr *zip.Reader
+	if path == "-" {
+		logInfo("Reading cycle ZIP from stdin...")
+		b, err := io.ReadAll(io.LimitReader(os.Stdin, maxZipBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(b) > maxZipBytes {
+			return fmt.Errorf("stdin: ZIP larger than %d MB", maxZipBytes>>20)
+		}
+		zr, err = openZipBytes(b)
+		if err != nil {
+			return fmt.Errorf("stdin: %w", err)
+		}
+	} else {
+		r, closeZip, err := openZipFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer closeZip()
+		zr = r
+	}
+
+	err := runPipeline(zr, time.Time{})
+	if err != nil {
+		setStatus("failed", err.Error())
+	}
+	return err
+}
+
+// updateFrom is update for repeated runs: it returns the cycle it built and,
+// when it reaches the already built cycle, stops without downloading it again.
+func updateFrom(built time.Time) (_ time.Time, err error) {
+	defer os.Remove(zipPath)
+
+	startStatus()
+	defer func() {
+		if err != nil {
+			setStatus("failed", err.Error())
+		}
+	}()
+
+	logInfo("Calculating NASR cycle dates...")
+
+	nextCycle := computeNextCycle()
+	currentCycle := nextCycle.Add(-cycleLengthDays * 24 * time.Hour)
+
+	cycles := []namedCycle{{"NEXT", nextCycle}, {"CURRENT", currentCycle}}
+	for i := 1; len(cycles) < *retryCycles; i++ {
+		older := currentCycle.AddDate(0, 0, -cycleLengthDays*i)
+		cycles = append(cycles, namedCycle{fmt.Sprintf("CURRENT-%d", i), older})
+	}
+	switch *prefer {
+	case "next":
+	case "current":
+		cycles[0], cycles[1] = cycles[1], cycles[0]
+	default:
+		return time.Time{}, fmt.Errorf("invalid -prefer %q (want next or current)", *prefer)
+	}
+	if *noFallback {
+		cycles = cycles[:1]
+	} else {
+		cycles = cycles[:*retryCycles]
+	}
+
+	var zr *zip.Reader
+	var zipData []byte
+	var cycle time.Time
+	for i, c := range cycles {
+		if c.date.Equal(built) {
+			logInfo(c.name + " cycle already built; nothing to do.")
+			setStatus("done", c.name+" cycle already built")
+			return built, nil
+		}
+		if airports, ok := loadParsed(c.date); ok {
+			logInfo(fmt.Sprintf("Using %s cycle %s from the parsed-dataset cache.", c.name, c.date.Format("2006-01-02")))
+			startBundle()
+			startManifest()
+			return c.date, exportAirports(airports, c.date, false)
+		}
+		logInfo("Trying "+c.name+" cycle:", formatZipURL(c.date))
+		setStatus("downloading", c.name+" cycle "+c.date.Format("2006-01-02"))
+
+		var err error
+		if *validateURL {
+			err = preflight(c.date)
+		}
+		if err == nil && *inMemory {
+			zr, zipData, err = downloadToMemory(c.date)
+		} else if err == nil {
+			err = download(c.date, zipPath)
+		}
+		if err == nil {
+			cycle = c.date
+			logInfo(fmt.Sprintf("Using %s cycle %s (attempt %d of %d).", c.name, c.date.Format("2006-01-02"), i+1, len(cycles)))
+			break
+		}
+		os.Remove(zipPath)
+
+		if i == len(cycles)-1 {
+			return time.Time{}, fmt.Errorf("failed to download %s cycle: %w", strings.ToLower(c.name), err)
+		}
+
+		var de *DownloadError
+		if errors.As(err, &de) && de.Status == http.StatusNotFound && c.date.Equal(nextCycle) {
+			logWarn(c.name + " cycle not published yet. Falling back to " + cycles[i+1].name + " cycle.")
+		} else {
+			logWarn(fmt.Sprintf("%s cycle not available (%v). Falling back to %s cycle.", c.name, err, cycles[i+1].name))
+		}
+	}
+
+	if *keepZip != "" {
+		err := keepCycleZip(*keepZip, cycle, zipData)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if zr == nil {
+		r, closeZip, err := openZipFile(zipPath)
+		if err != nil {
+			return time.Time{}, err
+		}
+		defer closeZip()
+		zr = r
+	}
+
+	return cycle, runPipeline(zr, cycle)
+}
+
+//
+// -----------------------------------------------------------------------------
+// LOOKUP
+// -----------------------------------------------------------------------------
+
+// lookupAirport prints a summary and the raw JSON for the airport whose LID
+// or ICAO matches id, reading the already generated dataset.
+func lookupAirport(id string) error {
+	airports, err := loadAirports(*outPath)
+	if err != nil {
+		return err
+	}
+
+	id = strings.ToUpper(strings.TrimSpace(id))
+	for _, ap := range airports {
+		if ap.ArptID != id && ap.ICAO != id {
+			continue
+		}
+
+		var fuels []string
+		for _, f := range fuelTypes {
+			if ap.Fuel.Has(f) {
+				fuels = append(fuels, f)
+			}
+		}
+		if fuels == nil {
+			fuels = []string{"none"}
+		}
+
+		fmt.Printf("%s (%s / %s)\n", ap.Name, ap.ArptID, ap.ICAO)
+		fmt.Printf("  %s, %s\n", ap.City, ap.State)
+		fmt.Printf("  %.6f, %.6f\n", ap.Lat, ap.Lon)
+		fmt.Printf("  Fuel: %s\n\n", strings.Join(fuels, ", "))
+
+		b, err := json.MarshalIndent(ap, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	return fmt.Errorf("airport %s not found in %s", id, *outPath)
+}
+
+//
+// -----------------------------------------------------------------------------
+// SELF TEST
+// -----------------------------------------------------------------------------
+
+// NASR lists ~19,000 U.S. facilities; far fewer means a broken download or
+// parser.
+const minSelfTestAirports = 10000
+
+// runSelfTest runs the full pipeline into a temporary directory under
+// -strict, checks the result, and removes everything it wrote.
+func runSelfTest() error {
+	tmp, err := os.MkdirTemp("", "mogas-selftest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	*outPath = filepath.Join(tmp, "airports.json")
+	*strict = true
+
+	err = update()
+	var airports []Airport
+	if err == nil {
+		airports, err = loadAirports(*outPath)
+	}
+	if err == nil && len(airports) < minSelfTestAirports {
+		err = fmt.Errorf("only %d airports parsed (want at least %d)", len(airports), minSelfTestAirports)
+	}
+	if err != nil {
+		fmt.Println("FAIL:", err)
+		return errors.New("self test failed")
+	}
+
+	counts := map[string]int{}
+	for _, ap := range airports {
+		for _, f := range fuelTypes {
+			if ap.Fuel.Has(f) {
+				counts[f]++
+			}
+		}
+	}
+	fmt.Printf("PASS: %d airports (mogas %d, 100ll %d, jet_a %d)\n",
+		len(airports), counts["mogas"], counts["100ll"], counts["jet_a"])
+	return nil
+}
+
+// fuelAssertion is one -assert check: the airport (LID or ICAO) must offer
+// the fuel.
+type fuelAssertion struct {
+	id, fuel string
+}
+
+// addAssertions parses an -assert value of comma-separated ID:fuel pairs.
+func addAssertions(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		id, fuel, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		id = strings.ToUpper(strings.TrimSpace(id))
+		if !ok || id == "" {
+			return fmt.Errorf("want ID:fuel, got %q", pair)
+		}
+		fuels, err := parseFuelFilter(fuel)
+		if err != nil {
+			return err
+		}
+		assertions = append(assertions, fuelAssertion{id, fuels[0]})
+	}
+	return nil
+}
+
+// checkAssertions verifies every -assert against the parsed airports and
+// reports all failures together.
+func checkAssertions(airports []Airport) error {
+	if len(assertions) == 0 {
+		return nil
+	}
+
+	var failed []string
+	for _, a := range assertions {
+		i := slices.IndexFunc(airports, func(ap Airport) bool {
+			return ap.ArptID == a.id || ap.ICAO == a.id
+		})
+		switch {
+		case i < 0:
+			failed = append(failed, a.id+" not in dataset")
+		case !airports[i].Fuel.Has(a.fuel):
+			failed = append(failed, fmt.Sprintf("%s has no %s (FUEL_TYPES parsed as %+v)", a.id, a.fuel, airports[i].Fuel))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("-assert failed: %s", strings.Join(failed, "; "))
+	}
+	logInfo(fmt.Sprintf("All %d -assert checks passed.", len(assertions)))
+	return nil
+}
+
+// checkMaxAge fails when the data's effective date is more than -max-age
+// before now, so a service can't keep publishing a dataset FAA stopped
+// updating. The date is the parsed EFF_DATE, or else the downloaded cycle
+// date; with neither, the check warns and passes.
+func checkMaxAge(cycle, now time.Time) error {
+	if *maxAge <= 0 {
+		return nil
+	}
+	eff, err := time.Parse("2006-01-02", dataCycle)
+	if err != nil {
+		eff = cycle
+	}
+	if eff.IsZero() {
+		logWarn("-max-age: no effective date in the data or cycle; not checked.")
+		return nil
+	}
+
+	age := now.Sub(eff)
+	if age > *maxAge {
+		return fmt.Errorf("data effective %s is %s old, over -max-age %s; refusing to write stale output",
+			eff.Format("2006-01-02"), age.Round(time.Hour), *maxAge)
+	}
+	logDebug(fmt.Sprintf("Data effective %s is %s old (-max-age %s).", eff.Format("2006-01-02"), age.Round(time.Hour), *maxAge))
+	return nil
+}
+
+//
+// -----------------------------------------------------------------------------
+// CYCLE CALCULATION
+// -----------------------------------------------------------------------------
+
+func computeNextCycle() time.Time {
+	now := time.Now().UTC()
+
+	daysSinceAnchor := now.Sub(anchorDate).Hours() / 24
+	n := int(daysSinceAnchor/float64(cycleLengthDays)) + 1
+
+	return anchorDate.Add(time.Duration(n*cycleLengthDays) * 24 * time.Hour)
+}
+
+func formatZipURL(t time.Time) string {
+	day := fmt.Sprintf("%02d", t.Day())
+	mon := t.Format("Jan")
+	year := t.Year()
+
+	file := fmt.Sprintf("%s_%s_%d_APT_CSV.zip", day, mon, year)
+	return "https://nfdc.faa.gov/webContent/28DaySub/extra/" + file
+}
+
+//
+// -----------------------------------------------------------------------------
+// DOWNLOAD + ZIP VALIDATION
+// -----------------------------------------------------------------------------
+
+// DownloadError reports which cycle failed to download and why.
+type DownloadError struct {
+	Cycle  time.Time
+	URL    string
+	Status int // HTTP status, 0 if no response was received
+	Err    error
+}
+
+func (e *DownloadError) Error() string {
+	switch e.Status {
+	case 0:
+		return e.Err.Error() // transport errors already name the URL
+	case http.StatusOK:
+		return fmt.Sprintf("%s: %v", e.URL, e.Err)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.Status, e.URL)
+}
+
+func (e *DownloadError) Unwrap() error { return e.Err }
+
+var errInvalidZip = errors.New("downloaded file is NOT a valid ZIP")
+
+var errNonZipResponse = errors.New("server returned a non-ZIP response (maintenance?)")
+
+// checkZipResponse sniffs the first bytes of a 200 response for the ZIP
+// magic number. FAA's CDN sometimes answers 200 with an HTML error or
+// maintenance page; that is reported with its Content-Type and opening text
+// instead of as a corrupt ZIP.
+func checkZipResponse(head []byte, contentType string) error {
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")) {
+		return nil
+	}
+	snippet := strings.Join(strings.Fields(string(head[:min(len(head), 80)])), " ")
+	return fmt.Errorf("%w: Content-Type %q, body starts %q", errNonZipResponse, contentType, snippet)
+}
+
+// httpClient makes every FAA request. It honors HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY; run swaps in an unverified client for -insecure-skip-verify.
+var httpClient = newHTTPClient(false)
+
+func newHTTPClient(insecure bool) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if insecure {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: t}
+}
+
+// download fetches the ZIP for cycle into path and validates it. Every
+// failure is a *DownloadError.
+func download(cycle time.Time, path string) error {
+	url := formatZipURL(cycle)
+	fail := func(status int, err error) error {
+		return &DownloadError{Cycle: cycle, URL: url, Status: status, Err: err}
+	}
+
+	resp, cached, err := fetchCycle(cycle)
+	if err != nil {
+		return fail(0, err)
+	}
+	if cached != nil {
+		if err := os.WriteFile(path, cached, 0644); err != nil {
+			return fail(http.StatusNotModified, err)
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fail(resp.StatusCode, errors.New(resp.Status))
+	}
+
+	body := bufio.NewReader(resp.Body)
+	head, _ := body.Peek(512)
+	if err := checkZipResponse(head, resp.Header.Get("Content-Type")); err != nil {
+		return fail(resp.StatusCode, err)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fail(resp.StatusCode, err)
+	}
+
+	_, err = io.Copy(out, body)
+	out.Close()
+	if err != nil {
+		return fail(resp.StatusCode, err)
+	}
+
+	if !isZipValid(path) {
+		return fail(resp.StatusCode, errInvalidZip)
+	}
+	if *cacheDir != "" {
+		if b, err := os.ReadFile(path); err == nil {
+			storeCached(cycle, b, resp.Header)
+		}
+	}
+	return nil
+}
+
+// preflight sends a HEAD request for the cycle ZIP so a missing cycle is
+// detected without a GET. Servers that don't support HEAD are treated as
+// "unknown" and left to the GET.
+func preflight(cycle time.Time) error {
+	url := formatZipURL(cycle)
+
+	resp, err := httpClient.Head(url)
+	if err != nil {
+		return &DownloadError{Cycle: cycle, URL: url, Err: err}
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.ContentLength > 0 {
+			logInfo(fmt.Sprintf("Cycle ZIP found (%.1f MB).", float64(resp.ContentLength)/(1<<20)))
+		}
+		return nil
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		logInfo("Server doesn't support HEAD; downloading directly.")
+		return nil
+	default:
+		return &DownloadError{Cycle: cycle, URL: url, Status: resp.StatusCode, Err: errors.New(resp.Status)}
+	}
+}
+
+// Upper bound on the ZIP size accepted by -in-memory; the APT ZIP is a few
+// tens of MB, so anything near this is not a NASR archive.
+const maxZipBytes = 512 << 20
+
+// downloadToMemory fetches the ZIP for cycle into memory and opens it. The
+// raw bytes are returned too, for -keep-zip.
+func downloadToMemory(cycle time.Time) (*zip.Reader, []byte, error) {
+	url := formatZipURL(cycle)
+	fail := func(status int, err error) error {
+		return &DownloadError{Cycle: cycle, URL: url, Status: status, Err: err}
+	}
+
+	resp, cached, err := fetchCycle(cycle)
+	if err != nil {
+		return nil, nil, fail(0, err)
+	}
+	if cached != nil {
+		zr, err := openZipBytes(cached)
+		if err != nil {
+			return nil, nil, fail(http.StatusNotModified, err)
+		}
+		return zr, cached, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, nil, fail(resp.StatusCode, errors.New(resp.Status))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxZipBytes+1))
+	if err != nil {
+		return nil, nil, fail(resp.StatusCode, err)
+	}
+	if len(b) > maxZipBytes {
+		return nil, nil, fail(resp.StatusCode, fmt.Errorf("ZIP larger than %d MB", maxZipBytes>>20))
+	}
+	if err := checkZipResponse(b, resp.Header.Get("Content-Type")); err != nil {
+		return nil, nil, fail(resp.StatusCode, err)
+	}
+
+	zr, err := openZipBytes(b)
+	if err != nil {
+		return nil, nil, fail(resp.StatusCode, errInvalidZip)
+	}
+	storeCached(cycle, b, resp.Header)
+	return zr, b, nil
+}
+
+// keepCycleZip archives the downloaded ZIP in dir under FAA's file name,
+// which carries the cycle date. data is the -in-memory download; without it
+// the ZIP is read back from zipPath.
+func keepCycleZip(dir string, cycle time.Time, data []byte) error {
+	if data == nil {
+		var err error
+		data, err = os.ReadFile(zipPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	url := formatZipURL(cycle)
+	dest := strings.TrimSuffix(dir, "/") + "/" + url[strings.LastIndex(url, "/")+1:]
+	logInfo("Keeping cycle ZIP:", dest)
+	return writeFile(dest, data)
+}
+
+// isZipValid reports whether path opens as a ZIP, or can be salvaged into
+// one that still has APT_BASE.csv (see salvageZip).
+func isZipValid(path string) bool {
+	r, err := zip.OpenReader(path)
+	if err == nil {
+		r.Close()
+		return true
+	}
+	b, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return false
+	}
+	_, err = salvageZip(b, err)
+	return err == nil
+}
+
+//
+// -----------------------------------------------------------------------------
+// PIPELINE
+// -----------------------------------------------------------------------------
+
+func runPipeline(zr *zip.Reader, cycle time.Time) error {
+	startBundle()
+	startManifest()
+
+	if *fuelOnly {
+		ok, err := refreshFuel(zr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := finishOutputs(); err != nil {
+				return err
+			}
+			logInfo("NASR fuel refresh completed successfully.")
+			setStatus("done", "fuel refreshed")
+			return nil
+		}
+		logWarn("Fuel-only refresh not possible. Falling back to full rebuild.")
+	}
+
+	logInfo("Parsing " + baseTable())
+	setStatus("parsing", baseTable())
+
+	if *reportUnknownFuel {
+		rows, err := loadBaseTable(zr)
+		if err != nil {
+			return err
+		}
+		return printUnknownFuel(rows)
+	}
+
+	airports, partial, err := buildAirports(zr)
+	if err != nil {
+		return err
+	}
+	if !partial {
+		storeParsed(cycle, airports)
+	}
+	return exportAirports(airports, cycle, partial)
+}
+
+// buildAirports parses, checks, and enriches every airport in the ZIP, sorted
+// by ID: the part of the pipeline -cache-dir keeps per cycle. partial reports
+// a -partial-ok result.
+func buildAirports(zr *zip.Reader) (_ []Airport, partial bool, err error) {
+	var airports []Airport
+	var rows [][]string
+	var loadErr error
+	for attempt := 1; ; attempt++ {
+		rows, loadErr = loadBaseTable(zr)
+		err = loadErr
+		if err == nil {
+			airports, err = parseAirports(rows)
+		}
+		if err == nil || errors.Is(err, errNotInZip) || errors.Is(err, errBadCoords) || attempt == maxParseAttempts {
+			break
+		}
+		logWarn("Parsing failed, re-extracting CSV from ZIP:", err)
+	}
+	if loadErr != nil && *partialOK && len(rows) > 1 {
+		logWarn(fmt.Sprintf("OUTPUT IS PARTIAL: %v; continuing with the %d rows read before the error (-partial-ok).",
+			loadErr, len(rows)-1))
+		partial = true
+		airports, err = parseAirports(rows)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	// A table whose every row is rejected would otherwise be written as null.
+	if len(airports) == 0 {
+		return nil, false, fmt.Errorf("no airports parsed from %s (%d rows rejected)", baseTable(), len(lastRejects))
+	}
+
+	err = sanityCheck(airports)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if *verifyOurAirports {
+		err = crossCheckOurAirports(airports)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if *rejectsPath != "" {
+		err = writeRejects(*rejectsPath, rejectsHeader, lastRejects)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	slices.SortFunc(airports, func(a, b Airport) int {
+		return strings.Compare(a.ArptID, b.ArptID)
+	})
+
+	setStatus("enriching", fmt.Sprintf("%d airports", len(airports)))
+	enrichAirports(zr, airports)
+	return airports, partial, nil
+}
+
+// exportAirports runs the rest of the pipeline on built airports: fuel
+// corrections, filters, options that add fields, and every output file.
+// cycle is the downloaded cycle, or zero for -zip.
+func exportAirports(airports []Airport, cycle time.Time, partial bool) error {
+	var err error
+
+	// Corrections are applied first so every fuel filter sees them.
+	if *fuelCorrections != "" {
+		corrections, err := loadFuelCorrections(*fuelCorrections)
+		if err != nil {
+			return err
+		}
+		applyFuelCorrections(airports, corrections)
+	}
+
+	if *types != "" {
+		airports = filterByType(airports, strings.Split(*types, ","))
+	}
+
+	if wantFuels != nil {
+		airports = filterByFuels(airports, wantFuels)
+	}
+	if excludeFuels != nil {
+		airports = excludeByFuels(airports, excludeFuels)
+	}
+
+	if wantRegions != nil {
+		airports = filterByRegion(airports, wantRegions)
+	}
+
+	if *towered != "" {
+		airports = filterByTower(airports, *towered == "yes")
+	}
+
+	if *onlyWithICAO {
+		airports = filterWithICAO(airports)
+	}
+
+	airports = checkICAOCollisions(airports, *dedupeByICAO)
+
+	if *includeIDs != "" {
+		airports, err = filterByIDFile(airports, *includeIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *withStateNames {
+		addStateNames(airports)
+	}
+	if *titleCaseNames {
+		addTitleCase(airports)
+	}
+	if len(homeBases) > 0 {
+		addNearestBases(airports, homeBases)
+	}
+
+	// Runway length is only known after the APT_RWY.csv join.
+	if *minRunway > 0 {
+		airports = filterByRunway(airports, *minRunway, *unknownRunway)
+	}
+
+	// Fail before writing, so a broken parse never replaces a good dataset.
+	if err := checkAssertions(airports); err != nil {
+		return err
+	}
+	if err := checkMaxAge(cycle, time.Now()); err != nil {
+		return err
+	}
+
+	if *countOnly {
+		printCounts(airports)
+		return nil
+	}
+
+	setStatus("writing", fmt.Sprintf("%d airports", len(airports)))
+
+	if *writeChanges {
+		prev, err := loadAirports(*outPath)
+		if err != nil {
+			logWarn("No previous dataset to compare; changes.json not written:", err)
+		} else {
+			changes := diffAirports(prev, airports)
+			err = writeJSON(siblingPath("changes.json"), changes)
+			if err != nil {
+				return err
+			}
+			logInfo(fmt.Sprintf("Changes: %d added, %d removed, %d fuel changed.",
+				len(changes.Added), len(changes.Removed), len(changes.FuelChanged)))
+		}
+	}
+
+	switch {
+	case *changedOnly != "":
+		var prev []Airport
+		prev, err = loadAirports(*changedOnly)
+		if err == nil {
+			delta := deltaAirports(prev, airports)
+			logInfo(fmt.Sprintf("Delta: %d changed airports.", len(delta)))
+			err = writeJSON(*outPath, delta)
+		}
+	case *sinceCycle != "":
+		err = writeCycleDelta(*outPath, *sinceCycle, airports)
+	default:
+		err = writeFormat(*outPath, airports)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *splitByFuel {
+		for _, fuel := range fuelTypes {
+			path := siblingPath(fuel + ".json")
+			err = writeJSON(path, projectAirports(filterByFuel(airports, fuel)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if *splitByState {
+		err = writeStateSplit(airports)
+		if err != nil {
+			return err
+		}
+	}
+
+	meta := Meta{
+		Generated: time.Now().UTC(),
+		Cycle:     dataCycle,
+		Count:     len(airports),
+		Bounds:    computeBounds(airports),
+		Partial:   partial,
+	}
+	err = writeJSON(siblingPath("meta.json"), meta)
+	if err != nil {
+		return err
+	}
+
+	if *summary {
+		err = writeJSON(siblingPath("summary.json"), summarizeByState(airports))
+		if err != nil {
+			return err
+		}
+	}
+
+	if *heatmap {
+		err = writeJSON(siblingPath("heatmap.json"), buildHeatmap(airports, *heatmapCell))
+		if err != nil {
+			return err
+		}
+	}
+
+	if *byICAO {
+		path := siblingPath("airports_by_icao.json")
+		err = writeJSON(path, indexByID(airports))
+		if err != nil {
+			return err
+		}
+	}
+
+	if *tilesDir != "" {
+		err = writeTiles(*tilesDir, airports, *tileMaxZoom)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = finishOutputs()
+	if err != nil {
+		return err
+	}
+
+	if partial {
+		logWarn(fmt.Sprintf("NASR update completed with PARTIAL data: %d airports written from a truncated %s.", len(airports), baseTable()))
+		setStatus("done", fmt.Sprintf("%d airports (partial)", len(airports)))
+		return nil
+	}
+	logInfo("NASR update completed successfully.")
+	setStatus("done", fmt.Sprintf("%d airports", len(airports)))
+	return nil
+}
+
+// refreshFuel patches the Fuel flags of the existing dataset from the freshly
+// CSV, leaving every other field untouched. It returns false when there is
+// no usable dataset, it wasn't written with this run's output options, or its
+// IDs don't match the CSV one-to-one.
+func refreshFuel(zr *zip.Reader) (bool, error) {
+	var existing []Airport
+	b, err := os.ReadFile(*outPath)
+	if err == nil {
+		existing, err = decodeAirports(b)
+	}
+	if err != nil {
+		logWarn("Cannot load existing dataset:", err)
+		return false, nil
+	}
+
+	// The patched dataset goes through the -format writer like a full run, so
+	// the existing one must be exactly what that writer produces. One written
+	// with other -fields, -rename, -fuel-format, or -home would come back with
+	// keys missing or reshaped.
+	var current bytes.Buffer
+	err = writers[*format].Write(existing, &current)
+	if err != nil {
+		logWarn("Cannot re-encode existing dataset:", err)
+		return false, nil
+	}
+	if at, have, want := firstDiff(bytes.TrimSpace(b), current.Bytes()); at >= 0 {
+		logWarn(fmt.Sprintf("Existing dataset was written with other output options (-fields, -rename, -fuel-format, -home): at byte %d it has %q where this run would write %q.", at, have, want))
+		return false, nil
+	}
+
+	logInfo("Parsing fuel column: " + baseTable())
+
+	rows, err := loadBaseTable(zr)
+	if err != nil {
+		return false, err
+	}
+	fuel, err := parseFuelByID(rows)
+	if err != nil {
+		return false, err
+	}
+
+	if len(fuel) != len(existing) {
+		logWarn(fmt.Sprintf("Airport count changed (%d -> %d).", len(existing), len(fuel)))
+		return false, nil
+	}
+
+	for i := range existing {
+		f, ok := fuel[existing[i].ArptID]
+		if !ok {
+			logWarn("Airport no longer in CSV:", existing[i].ArptID)
+			return false, nil
+		}
+		existing[i].Fuel = f
+	}
+
+	return true, writeFormat(*outPath, existing)
+}
+
+// firstDiff returns the offset of the first byte where a and b differ, with up
+// to 40 bytes of each from there, or -1 when they're equal.
+func firstDiff(a, b []byte) (int, string, string) {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) && i == len(b) {
+		return -1, "", ""
+	}
+	clip := func(p []byte) string { return string(p[i:min(len(p), i+40)]) }
+	return i, clip(a), clip(b)
+}
+
+// A tableJoin enriches airports from one secondary CSV in the ZIP. Every
+// secondary table is optional: when the ZIP lacks it, the fields it fills are
+// left empty.
+type tableJoin struct {
+	file  string
+	fills string // for the "not in ZIP" message
+	apply func(rows [][]string, airports []Airport)
+}
+
+// joins lists the secondary tables and their join keys. Each is indexed by
+// its key column, then matched against APT_BASE.csv ARPT_ID:
+//
+//	FRQ.csv      SERVICED_FACILITY  CTAF (NASR CSV subscription only)
+//	APT_RWY.csv  ARPT_ID            LongestRunwayFt
+//	APT_CON.csv  ARPT_ID            Manager, ManagerPhone, Owner
+//	APT_RMK.csv  ARPT_ID            FuelRemarks, FuelBrand
+var joins = []tableJoin{
+	{"FRQ.csv", "CTAF", joinCTAF},
+	{"APT_RWY.csv", "runway lengths", joinRunways},
+	{"APT_CON.csv", "manager and owner contacts", joinContacts},
+	{"APT_RMK.csv", "fuel remarks", joinFuelRemarks},
+}
+
+// enrichAirports applies every join in order, reading each table once from
+// the already open ZIP. A secondary table that can't be read (a corrupt
+// entry, bad CSV) is skipped with a warning; only APT_BASE.csv is required.
+func enrichAirports(zr *zip.Reader, airports []Airport) {
+	for _, j := range joins {
+		rows, err := loadTable(zr, j.file)
+		if errors.Is(err, errNotInZip) {
+			logInfo(j.file + " not in ZIP; " + j.fills + " left empty.")
+			continue
+		}
+		if err != nil {
+			logWarn(fmt.Sprintf("Skipping %s (%v); %s left empty.", j.file, err, j.fills))
+			continue
+		}
+		j.apply(rows, airports)
+	}
+}
+
+func joinCTAF(rows [][]string, airports []Airport) {
+	ctaf := parseCTAF(rows)
+	for i := range airports {
+		airports[i].CTAF = ctaf[airports[i].ArptID]
+	}
+}
+
+func joinRunways(rows [][]string, airports []Airport) {
+	longest := parseRunways(rows)
+	for i := range airports {
+		airports[i].LongestRunwayFt = longest[airports[i].ArptID]
+	}
+}
+
+func joinContacts(rows [][]string, airports []Airport) {
+	managers := parseContacts(rows, "MANAGER")
+	owners := parseContacts(rows, "OWNER")
+	for i := range airports {
+		m := managers[airports[i].ArptID]
+		airports[i].Manager = m.name
+		airports[i].ManagerPhone = m.phone
+		airports[i].Owner = owners[airports[i].ArptID].name
+	}
+}
+
+func joinFuelRemarks(rows [][]string, airports []Airport) {
+	remarks := parseFuelRemarks(rows)
+	for i := range airports {
+		r := remarks[airports[i].ArptID]
+		airports[i].FuelRemarks = r
+		airports[i].FuelBrand = detectFuelBrand(r)
+	}
+}
+
+//
+// -----------------------------------------------------------------------------
+// ZIP EXTRACTION
+// -----------------------------------------------------------------------------
+
+var errNotInZip = errors.New("not found in ZIP")
+
+// loadTable reads the named CSV from the ZIP. By default the entry is first
+// extracted to the working directory (and removed once read); -in-memory
+// reads it straight from the archive. A missing entry wraps errNotInZip.
+func loadTable(zr *zip.Reader, name string) ([][]string, error) {
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, name) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		if *inMemory {
+			return readCSV(name, rc)
+		}
+
+		csvPath, extractErr := extractCSV(rc, name)
+		if csvPath == "" {
+			return nil, extractErr
+		}
+		defer os.Remove(csvPath)
+
+		csvFile, err := os.Open(csvPath)
+		if err != nil {
+			return nil, err
+		}
+		defer csvFile.Close()
+
+		rows, err := readCSV(name, csvFile)
+		if extractErr != nil {
+			// The extracted copy stops where the entry failed, mid-row.
+			return dropLast(rows), fmt.Errorf("%s: %w", name, extractErr)
+		}
+		return rows, err
+	}
+
+	return nil, fmt.Errorf("%s %w", name, errNotInZip)
+}
+
+// extractCSV writes a ZIP entry to name in the working directory. If the
+// entry fails to decompress partway, the copy so far is kept and name is
+// returned with the error.
+func extractCSV(rc io.Reader, name string) (string, error) {
+	out, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, rc)
+	return name, err
+}
+
+//
+// -----------------------------------------------------------------------------
+// CSV PARSER
+// -----------------------------------------------------------------------------
+
+// readCSV reads every row of in. On a read or parse error it also returns
+// the complete rows before it, for -partial-ok.
+func readCSV(name string, in io.Reader) ([][]string, error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+
+	var rows [][]string
+	var readErr error
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("%s: %w", name, err)
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				// The underlying read failed, possibly mid-row.
+				rows = dropLast(rows)
+			}
+			break
+		}
+		rows = append(rows, row)
+	}
+	if readErr == nil && len(rows) == 0 {
+		return nil, fmt.Errorf("%s: no header row", name)
+	}
+
+	transcoded := 0
+	for _, row := range rows {
+		for i, f := range row {
+			if !utf8.ValidString(f) {
+				row[i] = latin1ToUTF8(f)
+				transcoded++
+			}
+		}
+	}
+	if transcoded > 0 {
+		logInfo(fmt.Sprintf("%s: transcoded %d non-UTF-8 fields as Latin-1.", name, transcoded))
+	}
+	return rows, readErr
+}
+
+// dropLast returns rows without its last row, which a failed read may have
+// cut short.
+func dropLast(rows [][]string) [][]string {
+	if len(rows) == 0 {
+		return rows
+	}
+	return rows[:len(rows)-1]
+}
+
+// latin1ToUTF8 decodes s as ISO-8859-1, where every byte is its own code
+// point. FAA exports are UTF-8 except for the odd Latin-1 accented name.
+func latin1ToUTF8(s string) string {
+	r := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
+
+// requireColumns fails if any of names is missing from header and has no
+// -column override.
+func requireColumns(table string, header []string, names ...string) error {
+	var missing []string
+	for _, n := range names {
+		if _, ok := columnOverride(table, n); !ok && !slices.Contains(header, n) {
+			missing = append(missing, n)
+		}
+	}
+	if missing != nil {
+		return fmt.Errorf("%s: missing required columns %s", table, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// -column overrides: "TABLE:COLUMN" or "COLUMN" (any table) to a 0-based
+// index.
+var columnOverrides map[string]int
+
+// parseColumnOverrides parses -column, e.g. "FUEL_TYPES=18,APT_RMK.csv:REMARK=5".
+func parseColumnOverrides(s string) (map[string]int, error) {
+	out := map[string]int{}
+	for _, pair := range strings.Split(s, ",") {
+		name, idx, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		i, err := strconv.Atoi(idx)
+		if !ok || name == "" || err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid -column %q (want [TABLE:]COLUMN=INDEX)", pair)
+		}
+		out[name] = i
+	}
+	return out, nil
+}
+
+func columnOverride(table, name string) (int, bool) {
+	if i, ok := columnOverrides[table+":"+name]; ok {
+		return i, true
+	}
+	i, ok := columnOverrides[name]
+	return i, ok
+}
+
+// columnLookup resolves column names to indices in header, honoring -column.
+// A name that appears more than once resolves to its first occurrence, with
+// a warning unless -column settles it, since FAA has no reason to ship
+// duplicate headers.
+func columnLookup(table string, header []string) func(name string) int {
+	seen := map[string][]int{}
+	for i, h := range header {
+		seen[h] = append(seen[h], i)
+	}
+	for _, h := range slices.Sorted(maps.Keys(seen)) {
+		if _, ok := columnOverride(table, h); ok {
+			continue
+		}
+		if at := seen[h]; len(at) > 1 {
+			logWarn(fmt.Sprintf("%s: duplicate column %s at indices %v; using %d (override with -column %s:%s=INDEX).",
+				table, h, at, at[0], table, h))
+		}
+	}
+
+	return func(name string) int {
+		if i, ok := columnOverride(table, name); ok {
+			return i
+		}
+		for i, h := range header {
+			if h == name {
+				return i
+			}
+		}
+		return -1
+	}
+}
+
+// field returns row[i], or "" when the column is missing or the row is short.
+func field(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// logColumns prints each resolved column index at debug level, flagging
+// columns that weren't found so column drift is obvious.
+func logColumns(table string, indices map[string]int) {
+	for _, name := range slices.Sorted(maps.Keys(indices)) {
+		if i := indices[name]; i < 0 {
+			logDebug(table, name, "-> MISSING (-1)")
+		} else {
+			logDebug(table, name, "->", i)
+		}
+	}
+}
+
+// dataRows returns the rows after the header that look like data: long
+// enough to hold every required column and with a non-blank ID. Blank or
+// truncated rows (e.g. trailing lines FAA sometimes appends) are skipped and
+// returned as rejects.
+func dataRows(rows [][]string, iID int, required ...int) ([][]string, []rejectedRow) {
+	minFields := iID + 1
+	for _, i := range required {
+		minFields = max(minFields, i+1)
+	}
+
+	out := make([][]string, 0, len(rows)-1)
+	var rejects []rejectedRow
+	for _, row := range rows[1:] {
+		switch {
+		case len(row) < minFields:
+			rejects = append(rejects, rejectedRow{"truncated row", row})
+		case strings.TrimSpace(row[iID]) == "":
+			rejects = append(rejects, rejectedRow{"blank ARPT_ID", row})
+		default:
+			out = append(out, row)
+		}
+	}
+
+	if len(rejects) > 0 {
+		logInfo(fmt.Sprintf("Skipped %d blank or truncated rows.", len(rejects)))
+	}
+	return out, rejects
+}
+
+func parseAirports(rows [][]string) ([]Airport, error) {
+	err := requireColumns("APT_BASE.csv", rows[0], "ARPT_ID", "LAT_DECIMAL", "LONG_DECIMAL", "FUEL_TYPES")
+	if err != nil {
+		return nil, err
+	}
+
+	col := columnLookup("APT_BASE.csv", rows[0])
+
+	iID := col("ARPT_ID")
+	iLat := col("LAT_DECIMAL")
+	iLon := col("LONG_DECIMAL")
+	iName := col("ARPT_NAME")
+	iCity := col("CITY")
+	iState := col("STATE_CODE")
+	iFuel := col("FUEL_TYPES")
+	iType := col("SITE_TYPE_CODE")
+	iICAO := col("ICAO_ID")
+	iOwnership := col("OWNERSHIP_TYPE_CODE")
+	iTower := col("TWR_TYPE_CODE")
+	iEff := col("EFF_DATE")
+	iRegion := col("REGION_CODE")
+	iDistrict := col("ADO_CODE")
+
+	logColumns("APT_BASE.csv", map[string]int{
+		"ARPT_ID": iID, "LAT_DECIMAL": iLat, "LONG_DECIMAL": iLon, "ARPT_NAME": iName,
+		"CITY": iCity, "STATE_CODE": iState, "FUEL_TYPES": iFuel, "SITE_TYPE_CODE": iType,
+		"ICAO_ID": iICAO, "OWNERSHIP_TYPE_CODE": iOwnership, "TWR_TYPE_CODE": iTower,
+		"EFF_DATE": iEff, "REGION_CODE": iRegion, "ADO_CODE": iDistrict,
+	})
+
+	var out, noCoords []Airport
+	var noCoordRows [][]string
+	var outside, swappedIDs []string
+
+	data, rejects := dataRows(rows, iID, iLat, iLon, iFuel)
+	for _, row := range data {
+		lat, lon, swapped, hasCoords := parseCoords(row, iLat, iLon)
+		id := strings.TrimSpace(field(row, iID))
+
+		if swapped {
+			swappedIDs = append(swappedIDs, id)
+			if !hasCoords {
+				rejects = append(rejects, rejectedRow{"latitude and longitude look swapped", row})
+				continue
+			}
+		}
+
+		if hasCoords && !*allowGlobal && !inUSBounds(lat, lon) {
+			outside = append(outside, fmt.Sprintf("%s (%g, %g)", id, lat, lon))
+			rejects = append(rejects, rejectedRow{"coordinates outside U.S. bounds", row})
+			continue
+		}
+
+		// A blank ICAO_ID means FAA assigned none; only guess without the column.
+		icao := strings.TrimSpace(field(row, iICAO))
+		if iICAO < 0 {
+			icao = deriveICAO(id, field(row, iState))
+		}
+
+		ap := Airport{
+			ArptID: id,
+			Name:   field(row, iName),
+			City:   field(row, iCity),
+			State:  field(row, iState),
+			ICAO:   icao,
+			Type:   parseSiteType(field(row, iType)),
+			Lat:    lat,
+			Lon:    lon,
+			Fuel:   ParseFuel(field(row, iFuel)),
+
+			OwnershipType: ownershipTypes[strings.ToUpper(strings.TrimSpace(field(row, iOwnership)))],
+			Towered:       parseTower(field(row, iTower)),
+
+			Region:   strings.ToUpper(strings.TrimSpace(field(row, iRegion))),
+			District: strings.ToUpper(strings.TrimSpace(field(row, iDistrict))),
+		}
+
+		if !hasCoords {
+			noCoords = append(noCoords, ap)
+			noCoordRows = append(noCoordRows, row)
+			continue
+		}
+		out = append(out, ap)
+
+		if *limit > 0 && len(out) >= *limit {
+			logInfo(fmt.Sprintf("Stopped after %d airports (-limit).", *limit))
+			break
+		}
+	}
+
+	if *strictCoords {
+		err := checkCoords(noCoords, swappedIDs, outside)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(swappedIDs) > 0 && *fixSwapped {
+		logWarn(fmt.Sprintf("Swapped latitude and longitude back for %d airports: %s",
+			len(swappedIDs), strings.Join(swappedIDs, ", ")))
+	} else if len(swappedIDs) > 0 {
+		logWarn(fmt.Sprintf("Skipped %d airports whose latitude and longitude look swapped (use -fix-swapped-coords to correct them): %s",
+			len(swappedIDs), strings.Join(swappedIDs, ", ")))
+	}
+	if len(outside) > 0 {
+		logWarn(fmt.Sprintf("Dropped %d airports with coordinates outside U.S. bounds (use -allow-global to keep them): %s",
+			len(outside), strings.Join(outside, ", ")))
+	}
+
+	if len(noCoords) > 0 {
+		if *geocode {
+			placed, unplaced := geocodeAirports(out, noCoords)
+			out = append(out, placed...)
+			for _, i := range unplaced {
+				rejects = append(rejects, rejectedRow{"missing coordinates and no located airport in the same city", noCoordRows[i]})
+			}
+		} else {
+			logWarn(fmt.Sprintf("Skipped %d airports without coordinates (use -geocode to approximate).", len(noCoords)))
+			for _, row := range noCoordRows {
+				rejects = append(rejects, rejectedRow{"missing or invalid coordinates", row})
+			}
+		}
+	}
+	rejectsHeader, lastRejects = rows[0], rejects
+	dataCycle = ""
+	if len(data) > 0 {
+		dataCycle = parseEffDate(field(data[0], iEff))
+	}
+
+	if *limit > 0 && len(out) > *limit {
+		out = out[:*limit]
+	}
+
+	return out, nil
+}
+
+var errBadCoords = errors.New("records with bad coordinates (-strict-coords)")
+
+// checkCoords reports, for -strict-coords, every airport parseAirports found
+// with missing, invalid, swapped, or out-of-bounds coordinates, and fails if
+// there are any. Fixed swaps (-fix-swapped-coords) still count.
+func checkCoords(noCoords []Airport, swapped, outside []string) error {
+	missing := make([]string, len(noCoords))
+	for i, ap := range noCoords {
+		missing[i] = ap.ArptID
+	}
+
+	n := 0
+	for _, r := range []struct {
+		reason string
+		ids    []string
+	}{
+		{"missing, zero, or out-of-range coordinates", missing},
+		{"latitude and longitude swapped", swapped},
+		{"outside U.S. bounds", outside},
+	} {
+		if len(r.ids) > 0 {
+			logError(fmt.Sprintf("%d airports with %s: %s", len(r.ids), r.reason, strings.Join(r.ids, ", ")))
+			n += len(r.ids)
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%d %w", n, errBadCoords)
+	}
+	return nil
+}
+
+// Effective date of the last parsed APT_BASE.csv, for meta.json.
+var dataCycle string
+
+// parseEffDate converts an EFF_DATE value (2026/10/01) to 2026-10-01, or ""
+// when it is blank or malformed.
+func parseEffDate(s string) string {
+	t, err := time.Parse("2006/01/02", strings.TrimSpace(s))
+	if err != nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// parseCoords parses the decimal lat/lon columns. Blank, malformed, 0,0, or
+// out-of-range coordinates report ok=false. swapped reports a latitude beyond
+// ±90 that would be a valid longitude, with a latitude in the longitude
+// column; -fix-swapped-coords swaps such pairs back and reports ok=true.
+func parseCoords(row []string, iLat, iLon int) (lat, lon float64, swapped, ok bool) {
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(field(row, iLat)), 64)
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(field(row, iLon)), 64)
+	if errLat != nil || errLon != nil || (lat == 0 && lon == 0) {
+		return 0, 0, false, false
+	}
+
+	swapped = math.Abs(lat) > 90 && math.Abs(lat) <= 180 && math.Abs(lon) <= 90
+	if swapped && *fixSwapped {
+		lat, lon = lon, lat
+	}
+	if math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return 0, 0, swapped, false
+	}
+	return lat, lon, swapped, true
+}
+
+// parseTower maps TWR_TYPE_CODE to towered or not. The codes name the
+// facility (ATCT, ATCT-A/C, NON-ATCT, ...), so only the prefix matters.
+func parseTower(code string) *bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	var towered bool
+	switch {
+	case strings.HasPrefix(code, "NON-ATCT"):
+		towered = false
+	case strings.HasPrefix(code, "ATCT"):
+		towered = true
+	default:
+		return nil
+	}
+	return &towered
+}
+
+// inUSBounds reports whether lat, lon falls in one of usRegions.
+func inUSBounds(lat, lon float64) bool {
+	for _, r := range usRegions {
+		if lat >= r.minLat && lat <= r.maxLat && lon >= r.minLon && lon <= r.maxLon {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSiteType(code string) string {
+	code = strings.TrimSpace(code)
+	if t, ok := siteTypes[code]; ok {
+		return t
+	}
+	return code
+}
+
+// ICAO prefixes of the states and territories outside the contiguous U.S.,
+// whose airports are "K" + LID.
+var icaoPrefixes = map[string]string{
+	"AK": "PA",
+	"HI": "PH",
+	"PR": "TJ",
+	"VI": "TI",
+	"GU": "PG",
+	"MP": "PG",
+	"AS": "NS",
+}
+
+// deriveICAO guesses the ICAO code of a 3-letter LID for ZIPs without an
+// ICAO_ID column. In the contiguous U.S. it is "K" + LID. Elsewhere the code
+// only follows the LID when the LID starts with the prefix's last letter
+// (ANC → PANC, HNL → PHNL); others are assigned independently (FAI is PAFA),
+// so no guess is made. LIDs with digits, like private strips, have no ICAO.
+func deriveICAO(lid, state string) string {
+	if len(lid) != 3 || strings.ContainsFunc(lid, func(r rune) bool { return r < 'A' || r > 'Z' }) {
+		return ""
+	}
+
+	prefix, ok := icaoPrefixes[strings.TrimSpace(state)]
+	if !ok {
+		return "K" + lid
+	}
+	if lid[0] == prefix[len(prefix)-1] {
+		return prefix[:len(prefix)-1] + lid
+	}
+	return ""
+}
+
+// parseCTAF returns the CTAF frequency per serviced ARPT_ID from FRQ.csv.
+func parseCTAF(rows [][]string) map[string]string {
+	col := columnLookup("FRQ.csv", rows[0])
+	iID := col("SERVICED_FACILITY")
+	iFreq := col("FREQ")
+	iUse := col("FREQ_USE")
+
+	out := map[string]string{}
+	for _, row := range rows[1:] {
+		if !strings.Contains(strings.ToUpper(field(row, iUse)), "CTAF") {
+			continue
+		}
+		id := strings.TrimSpace(field(row, iID))
+		if _, seen := out[id]; !seen {
+			out[id] = strings.TrimSpace(field(row, iFreq))
+		}
+	}
+
+	return out
+}
+
+// parseRunways returns the longest RWY_LEN in APT_RWY.csv per airport.
+// Blank or unparsable lengths are ignored.
+func parseRunways(rows [][]string) map[string]int {
+	col := columnLookup("APT_RWY.csv", rows[0])
+	iID := col("ARPT_ID")
+	iLen := col("RWY_LEN")
+
+	out := map[string]int{}
+	for _, row := range rows[1:] {
+		n, err := strconv.Atoi(strings.TrimSpace(field(row, iLen)))
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSpace(field(row, iID))
+		out[id] = max(out[id], n)
+	}
+
+	return out
+}
+
+type contact struct {
+	name, phone string
+}
+
+// parseContacts returns the first APT_CON.csv contact per airport whose TITLE
+// is title (MANAGER, OWNER).
+func parseContacts(rows [][]string, title string) map[string]contact {
+	col := columnLookup("APT_CON.csv", rows[0])
+	iID := col("ARPT_ID")
+	iTitle := col("TITLE")
+	iName := col("NAME")
+	iPhone := col("PHONE_NO")
+
+	out := map[string]contact{}
+	for _, row := range rows[1:] {
+		if strings.TrimSpace(field(row, iTitle)) != title {
+			continue
+		}
+		id := strings.TrimSpace(field(row, iID))
+		if _, seen := out[id]; !seen {
+			out[id] = contact{strings.TrimSpace(field(row, iName)), strings.TrimSpace(field(row, iPhone))}
+		}
+	}
+
+	return out
+}
+
+// parseFuelRemarks joins each airport's FUEL_TYPES remarks from APT_RMK.csv.
+func parseFuelRemarks(rows [][]string) map[string]string {
+	col := columnLookup("APT_RMK.csv", rows[0])
+	iID := col("ARPT_ID")
+	iRef := col("REF_COL_NAME")
+	iRemark := col("REMARK")
+
+	out := map[string]string{}
+	for _, row := range rows[1:] {
+		if strings.TrimSpace(field(row, iRef)) != "FUEL_TYPES" {
+			continue
+		}
+		id := strings.TrimSpace(field(row, iID))
+		remark := strings.TrimSpace(field(row, iRemark))
+		if out[id] != "" {
+			remark = out[id] + " " + remark
+		}
+		out[id] = remark
+	}
+
+	return out
+}
+
+func detectFuelBrand(remark string) string {
+	return fuelBrandPattern.FindString(strings.ToUpper(remark))
+}
+
+// parseFuelByID reads only the ID and fuel columns, keyed by ARPT_ID. Rows
+// parseAirports would skip for their coordinates are skipped here too.
+func parseFuelByID(rows [][]string) (map[string]Fuel, error) {
+	err := requireColumns("APT_BASE.csv", rows[0], "ARPT_ID", "FUEL_TYPES")
+	if err != nil {
+		return nil, err
+	}
+
+	col := columnLookup("APT_BASE.csv", rows[0])
+	iID := col("ARPT_ID")
+	iFuel := col("FUEL_TYPES")
+	iLat := col("LAT_DECIMAL")
+	iLon := col("LONG_DECIMAL")
+
+	out := make(map[string]Fuel, len(rows)-1)
+	data, _ := dataRows(rows, iID, iLat, iLon, iFuel)
+	for _, row := range data {
+		lat, lon, swapped, ok := parseCoords(row, iLat, iLon)
+		if !ok && (swapped || !*geocode) || ok && !*allowGlobal && !inUSBounds(lat, lon) {
+			continue
+		}
+		out[strings.TrimSpace(field(row, iID))] = ParseFuel(field(row, iFuel))
+	}
+
+	return out, nil
+}
+
+// fuelTokens splits a FUEL_TYPES value into uppercase tokens with the
+// punctuation FAA uses inside codes ("100-LL", "JET-A+", "100/130") removed.
+func fuelTokens(s string) []string {
+	s = strings.ReplaceAll(strings.ToUpper(s), "AUTO FUEL", "AUTOFUEL")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+
+	strip := strings.NewReplacer("-", "", "/", "", "+", "")
+	for i, f := range fields {
+		fields[i] = strip.Replace(f)
+	}
+	return fields
+}
+
+// ParseFuel parses a NASR FUEL_TYPES value into fuel flags.
+//
+// raw is split on commas, semicolons and spaces after uppercasing ("AUTO FUEL"
+// is kept as one code), and "-", "/" and "+" are removed from each code. Each
+// code then sets at most one flag, looked up in the fuel keyword table
+// (fuel_keywords.txt, overridable with -fuel-map). With the defaults:
+//
+//   - "MOGAS", "AUTO", "AUTOGAS", "AUTOFUEL" set MoGas; "100", "100LL",
+//     "100130", "AVGAS" set Avgas100LL; "A", "A1", "JET", "JETA", "JETA1"
+//     set JetA
+//   - failing an exact match, any code containing "MOGAS" (e.g. "MOGAS91")
+//     sets MoGas
+//
+// Other codes ("80", "B", "UL94", "100UL", ...) are ignored, so "" and unrecognized
+// values yield the zero Fuel. For example "100LL,A" and "100-LL JET-A+" both
+// give {Avgas100LL: true, JetA: true}, and "A++" gives {JetA: true}.
+func ParseFuel(raw string) Fuel {
+	var fuel Fuel
+
+	for _, tok := range fuelTokens(raw) {
+		if key := keywords.lookup(tok); key != "" {
+			fuel.set(key)
+		}
+	}
+
+	return fuel
+}
+
+//
+// -----------------------------------------------------------------------------
+// SANITY CHECKS
+// -----------------------------------------------------------------------------
+
+// sanityCheck flags parse results that are almost certainly a parser bug
+// (e.g. FAA moved a column). It warns, or fails under -strict.
+func sanityCheck(airports []Airport) error {
+	for _, ap := range airports {
+		if ap.Fuel.Any() {
+			return nil
+		}
+	}
+
+	msg := fmt.Sprintf("none of %d airports report any fuel; FUEL_TYPES parsing is likely broken", len(airports))
+	if *strict {
+		return errors.New(msg)
+	}
+	logWarn("!!!", msg, "!!!")
+	return nil
+}
+
+//
+// -----------------------------------------------------------------------------
+// METADATA
+// -----------------------------------------------------------------------------
+
+// computeBounds returns the min/max lat/lon over airports with coordinates,
+// or nil when none have any.
+func computeBounds(airports []Airport) *Bounds {
+	var b *Bounds
+	for _, ap := range airports {
+		if ap.Lat == 0 && ap.Lon == 0 {
+			continue
+		}
+		if b == nil {
+			b = &Bounds{ap.Lat, ap.Lon, ap.Lat, ap.Lon}
+			continue
+		}
+		b.MinLat = min(b.MinLat, ap.Lat)
+		b.MinLon = min(b.MinLon, ap.Lon)
+		b.MaxLat = max(b.MaxLat, ap.Lat)
+		b.MaxLon = max(b.MaxLon, ap.Lon)
+	}
+	return b
+}
+
+// summarizeByState counts airports per state. Airports without a state are
+// counted under "unknown".
+func summarizeByState(airports []Airport) map[string]StateSummary {
+	out := map[string]StateSummary{}
+	for _, ap := range airports {
+		state := ap.State
+		if state == "" {
+			state = "unknown"
+		}
+
+		s, ok := out[state]
+		if !ok {
+			s.Fuel = make(map[string]int, len(fuelTypes))
+			for _, fuel := range fuelTypes {
+				s.Fuel[fuel] = 0
+			}
+		}
+		s.Airports++
+		for _, fuel := range fuelTypes {
+			if ap.Fuel.Has(fuel) {
+				s.Fuel[fuel]++
+			}
+		}
+		out[state] = s
+	}
+	return out
+}
+
+// printCounts prints the -count-only totals, summed from summarizeByState.
+func printCounts(airports []Airport) {
+	states := summarizeByState(airports)
+	total := map[string]int{}
+	for _, s := range states {
+		for fuel, n := range s.Fuel {
+			total[fuel] += n
+		}
+	}
+
+	fmt.Printf("%d airports in %d states (mogas %d, 100ll %d, jet_a %d)\n",
+		len(airports), len(states), total["mogas"], total["100ll"], total["jet_a"])
+}
+
+//
+// -----------------------------------------------------------------------------
+// DIFF
+// -----------------------------------------------------------------------------
+
+// diffAirports compares two datasets by ARPT_ID. Lists are sorted by ID.
+func diffAirports(prev, cur []Airport) Changes {
+	before := make(map[string]Airport, len(prev))
+	for _, ap := range prev {
+		before[ap.ArptID] = ap
+	}
+
+	c := Changes{
+		Generated:   time.Now().UTC(),
+		Added:       []string{},
+		Removed:     []string{},
+		FuelChanged: []FuelChange{},
+	}
+
+	seen := make(map[string]bool, len(cur))
+	for _, ap := range cur {
+		seen[ap.ArptID] = true
+		old, ok := before[ap.ArptID]
+		if !ok {
+			c.Added = append(c.Added, ap.ArptID)
+			continue
+		}
+		if old.Fuel != ap.Fuel {
+			c.FuelChanged = append(c.FuelChanged, FuelChange{ap.ArptID, old.Fuel, ap.Fuel})
+		}
+	}
+	for _, ap := range prev {
+		if !seen[ap.ArptID] {
+			c.Removed = append(c.Removed, ap.ArptID)
+		}
+	}
+
+	slices.Sort(c.Added)
+	slices.Sort(c.Removed)
+	slices.SortFunc(c.FuelChanged, func(a, b FuelChange) int {
+		return strings.Compare(a.ArptID, b.ArptID)
+	})
+	return c
+}
+
+// writeCycleDelta writes the -since-cycle delta from the dataset at prevPath
+// to airports. The previous cycle is read from the meta.json next to it.
+func writeCycleDelta(path, prevPath string, airports []Airport) error {
+	prev, err := loadAirports(prevPath)
+	if err != nil {
+		return err
+	}
+
+	from := ""
+	b, err := os.ReadFile(filepath.Join(filepath.Dir(prevPath), "meta.json"))
+	var prevMeta Meta
+	if err == nil && json.Unmarshal(b, &prevMeta) == nil {
+		from = prevMeta.Cycle
+	}
+	if from == "" {
+		logWarn("No cycle date in the meta.json next to " + prevPath + "; from_cycle left empty.")
+	} else if f, err1 := time.Parse("2006-01-02", from); err1 == nil {
+		if t, err2 := time.Parse("2006-01-02", dataCycle); err2 == nil && t.Sub(f) != cycleLengthDays*24*time.Hour {
+			logWarn(fmt.Sprintf("%s -> %s is not a single cycle step; the delta spans both anyway.", from, dataCycle))
+		}
+	}
+
+	delta := CycleDelta{FromCycle: from, ToCycle: dataCycle, Airports: deltaAirports(prev, airports)}
+	logInfo(fmt.Sprintf("Cycle delta %s -> %s: %d changed airports.", from, dataCycle, len(delta.Airports)))
+	return writeJSON(path, delta)
+}
+
+// deltaAirports lists the airports added, removed, or with changed fuel
+// flags since prev, sorted by ID, for clients patching a cached dataset.
+func deltaAirports(prev, cur []Airport) []AirportDelta {
+	byID := make(map[string]Airport, len(prev)+len(cur))
+	for _, ap := range prev {
+		byID[ap.ArptID] = ap
+	}
+	for _, ap := range cur {
+		byID[ap.ArptID] = ap
+	}
+
+	c := diffAirports(prev, cur)
+	out := []AirportDelta{}
+	for _, id := range c.Added {
+		out = append(out, AirportDelta{Change: "added", Airport: byID[id]})
+	}
+	for _, id := range c.Removed {
+		out = append(out, AirportDelta{Change: "removed", Airport: byID[id]})
+	}
+	for _, fc := range c.FuelChanged {
+		out = append(out, AirportDelta{Change: "fuel_changed", Airport: byID[fc.ArptID], FuelBefore: &fc.Before})
+	}
 
-type Airport struct {
-	ArptID string          `json:"arpt_id"`
-	Name   string          `json:"name"`
-	City   string          `json:"city"`
-	State  string          `json:"state"`
-	ICAO   string          `json:"icao"`
-	Lat    float64         `json:"lat"`
-	Lon    float64         `json:"lon"`
-	Fuel   map[string]bool `json:"fuel"`
+	slices.SortFunc(out, func(a, b AirportDelta) int {
+		return strings.Compare(a.ArptID, b.ArptID)
+	})
+	return out
 }
 
 //
 // -----------------------------------------------------------------------------
-// CONSTANTS
+// FILTERING
 // -----------------------------------------------------------------------------
 
-// FAA NASR known anchor cycle date
-// This corresponds to: 25_Dec_2025_APT_CSV.zip
-var anchorDate = time.Date(2025, 12, 25, 0, 0, 0, 0, time.UTC)
+func filterByFuel(airports []Airport, fuel string) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if ap.Fuel.Has(fuel) {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
 
-const cycleLengthDays = 28
+// filterByFuels keeps airports offering any of fuels.
+func filterByFuels(airports []Airport, fuels []string) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if slices.ContainsFunc(fuels, ap.Fuel.Has) {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
 
-//
-// -----------------------------------------------------------------------------
-// MAIN ENTRY
-// -----------------------------------------------------------------------------
+// parseFuelFilter parses -fuel into fuelTypes keys.
+func parseFuelFilter(s string) ([]string, error) {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if !slices.Contains(fuelTypes, f) {
+			return nil, fmt.Errorf("unknown fuel %q (valid: %s)", f, strings.Join(fuelTypes, ", "))
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
 
-func main() {
-	fmt.Println("[INFO] Calculating NASR cycle dates...")
+// excludeByFuels drops airports that offer any of fuels.
+func excludeByFuels(airports []Airport, fuels []string) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if !slices.ContainsFunc(fuels, ap.Fuel.Has) {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
 
-	nextCycle := computeNextCycle()
-	nextURL := formatZipURL(nextCycle)
+// filterByRegion keeps airports whose FAA region is one of want.
+func filterByRegion(airports []Airport, want []string) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if slices.Contains(want, ap.Region) {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
+
+// parseRegions parses -regions. Codes not in faaRegions are kept, since
+// NASR may carry codes this table lacks, but draw a warning in case of a typo.
+func parseRegions(s string) []string {
+	var out []string
+	for _, code := range strings.Split(s, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if _, ok := faaRegions[code]; !ok {
+			logWarn(fmt.Sprintf("-regions: %q is not a known FAA region code; keeping it as given.", code))
+		}
+		out = append(out, code)
+	}
+	return out
+}
 
-	fmt.Println("[INFO] Trying NEXT cycle:", nextURL)
+func filterByType(airports []Airport, keep []string) []Airport {
+	want := map[string]bool{}
+	for _, t := range keep {
+		want[strings.TrimSpace(t)] = true
+	}
 
-	// Try downloading NEXT cycle
-	err := download(nextURL, "cycle.zip")
-	if err != nil || !isZipValid("cycle.zip") {
-		fmt.Println("[WARN] Next cycle not available. Falling back to CURRENT cycle.")
+	out := []Airport{}
+	for _, ap := range airports {
+		if want[ap.Type] {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
 
-		os.Remove("cycle.zip")
+// checkICAOCollisions warns about ICAO codes shared by more than one airport,
+// which make ICAO lookups (-by-icao, -airport) ambiguous. With drop, only one
+// airport per ICAO is kept: the one whose LID is the ICAO's tail (PAO for
+// KPAO), else the first by LID.
+func checkICAOCollisions(airports []Airport, drop bool) []Airport {
+	byICAO := map[string][]int{}
+	for i, ap := range airports {
+		if ap.ICAO != "" {
+			byICAO[ap.ICAO] = append(byICAO[ap.ICAO], i)
+		}
+	}
 
-		currentCycle := nextCycle.Add(-cycleLengthDays * 24 * time.Hour)
-		currentURL := formatZipURL(currentCycle)
+	var collisions []string
+	dropped := map[int]bool{}
+	for _, icao := range slices.Sorted(maps.Keys(byICAO)) {
+		idx := byICAO[icao]
+		if len(idx) < 2 {
+			continue
+		}
+		ids := make([]string, len(idx))
+		keep := idx[0]
+		for j, i := range idx {
+			ids[j] = airports[i].ArptID
+			if strings.HasSuffix(icao, airports[i].ArptID) && len(icao)-len(airports[i].ArptID) <= 1 {
+				keep = i
+			}
+		}
+		collisions = append(collisions, icao+" ("+strings.Join(ids, ", ")+")")
+		for _, i := range idx {
+			dropped[i] = i != keep
+		}
+	}
+	if len(collisions) == 0 {
+		return airports
+	}
 
-		fmt.Println("[INFO] Current cycle URL:", currentURL)
+	if !drop {
+		logWarn(fmt.Sprintf("%d ICAO codes are shared by more than one airport (use -dedupe-by-icao to keep one each): %s",
+			len(collisions), strings.Join(collisions, "; ")))
+		return airports
+	}
 
-		err2 := download(currentURL, "cycle.zip")
-		if err2 != nil {
-			panic(fmt.Errorf("failed to download current cycle: %w", err2))
+	out := []Airport{}
+	for i, ap := range airports {
+		if !dropped[i] {
+			out = append(out, ap)
 		}
+	}
+	logWarn(fmt.Sprintf("Dropped %d airports sharing an ICAO code with another (-dedupe-by-icao): %s",
+		len(airports)-len(out), strings.Join(collisions, "; ")))
+	return out
+}
 
-		if !isZipValid("cycle.zip") {
-			panic("Downloaded current cycle but it is NOT a valid ZIP.")
+// filterWithICAO drops airports without an ICAO identifier.
+func filterWithICAO(airports []Airport) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if ap.ICAO != "" {
+			out = append(out, ap)
 		}
 	}
+	logInfo(fmt.Sprintf("Excluded %d airports without an ICAO identifier (-only-with-icao).", len(airports)-len(out)))
+	return out
+}
 
-	runPipeline("cycle.zip")
+// filterByTower keeps airports whose tower status is known and equals want.
+func filterByTower(airports []Airport, want bool) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if ap.Towered != nil && *ap.Towered == want {
+			out = append(out, ap)
+		}
+	}
+	return out
 }
 
-//
-// -----------------------------------------------------------------------------
-// CYCLE CALCULATION
-// -----------------------------------------------------------------------------
+// filterByRunway keeps airports whose longest runway is at least minFt.
+// Airports with no known runway length are kept only if keepUnknown is set.
+func filterByRunway(airports []Airport, minFt int, keepUnknown bool) []Airport {
+	out := []Airport{}
+	for _, ap := range airports {
+		if ap.LongestRunwayFt >= minFt || ap.LongestRunwayFt == 0 && keepUnknown {
+			out = append(out, ap)
+		}
+	}
+	return out
+}
 
-func computeNextCycle() time.Time {
-	now := time.Now().UTC()
+// filterByIDFile keeps the airports whose LID or ICAO is listed in path, one
+// per line. Blank lines and # comments are ignored; unmatched IDs are warned.
+func filterByIDFile(airports []Airport, path string) ([]Airport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-	daysSinceAnchor := now.Sub(anchorDate).Hours() / 24
-	n := int(daysSinceAnchor/float64(cycleLengthDays)) + 1
+	want := map[string]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if id := strings.ToUpper(strings.TrimSpace(line)); id != "" {
+			want[id] = false
+		}
+	}
 
-	return anchorDate.Add(time.Duration(n*cycleLengthDays) * 24 * time.Hour)
-}
+	out := []Airport{}
+	for _, ap := range airports {
+		_, byLID := want[ap.ArptID]
+		_, byICAO := want[ap.ICAO]
+		if byLID || byICAO {
+			want[ap.ArptID] = true
+			want[ap.ICAO] = true
+			out = append(out, ap)
+		}
+	}
 
-func formatZipURL(t time.Time) string {
-	day := fmt.Sprintf("%02d", t.Day())
-	mon := t.Format("Jan")
-	year := t.Year()
+	var missing []string
+	for id, found := range want {
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+	slices.Sort(missing)
+	for _, id := range missing {
+		logWarn("Included ID not found:", id)
+	}
 
-	file := fmt.Sprintf("%s_%s_%d_APT_CSV.zip", day, mon, year)
-	return "https://nfdc.faa.gov/webContent/28DaySub/extra/" + file
+	return out, nil
 }
 
 //
 // -----------------------------------------------------------------------------
-// DOWNLOAD + ZIP VALIDATION
+// INDEXES
 // -----------------------------------------------------------------------------
 
-func download(url, path string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
+// indexByID maps each airport's ICAO and LID to the airport so clients can
+// look one up without scanning the array. Empty identifiers are skipped.
+func indexByID(airports []Airport) map[string]any {
+	out := make(map[string]any, 2*len(airports))
+	for _, ap := range airports {
+		v := projectAirport(ap)
+		if ap.ICAO != "" {
+			out[ap.ICAO] = v
+		}
+		if ap.ArptID != "" && ap.ArptID != ap.ICAO {
+			out[ap.ArptID] = v
+		}
 	}
-	defer resp.Body.Close()
+	return out
+}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, url)
+//
+// -----------------------------------------------------------------------------
+// FIELD SELECTION
+// -----------------------------------------------------------------------------
+
+// JSON keys chosen with -fields; nil emits every field.
+var selectedFields []string
+
+// airportFields returns the JSON keys an Airport can emit, in struct order.
+func airportFields() []string {
+	var out []string
+	for _, f := range jsonFields(reflect.TypeOf(Airport{})) {
+		out = append(out, f.name)
 	}
+	return out
+}
 
-	out, err := os.Create(path)
-	if err != nil {
-		return err
+func parseFields(s string) ([]string, error) {
+	valid := airportFields()
+
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "id" {
+			f = "arpt_id"
+		}
+		if !slices.Contains(valid, f) {
+			return nil, fmt.Errorf("unknown field %q (valid: %s)", f, strings.Join(valid, ", "))
+		}
+		out = append(out, f)
 	}
-	defer out.Close()
+	return out, nil
+}
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+// -rename pairs, old key to new key; nil keeps the struct's keys.
+var renames map[string]string
+
+// parseRenames parses -rename. Source keys must be Airport JSON keys (or a
+// distance key, added by -home).
+func parseRenames(s string) (map[string]string, error) {
+	valid := append(airportFields(), "distance_nm", "distance_sm", "distance_km")
+
+	out := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid -rename pair %q (want old:new)", pair)
+		}
+		if !slices.Contains(valid, from) {
+			return nil, fmt.Errorf("unknown field %q in -rename (valid: %s)", from, strings.Join(valid, ", "))
+		}
+		out[from] = to
+	}
+	return out, nil
 }
 
-func isZipValid(path string) bool {
-	r, err := zip.OpenReader(path)
-	if err != nil {
-		return false
+// outputKey returns the emitted name of a JSON key after -rename.
+func outputKey(key string) string {
+	if to, ok := renames[key]; ok {
+		return to
 	}
-	r.Close()
-	return true
+	return key
 }
 
-//
-// -----------------------------------------------------------------------------
-// PIPELINE
-// -----------------------------------------------------------------------------
+// projecting reports whether -fields, -rename, or -fuel-format array reshape
+// each airport.
+func projecting() bool {
+	return selectedFields != nil || renames != nil || *fuelFormat == "array"
+}
 
-func runPipeline(zipPath string) {
-	csvPath, err := extractCSV(zipPath)
-	if err != nil {
-		panic(err)
+// projectAirport returns ap itself, or a map of its -fields keys (default
+// all) renamed by -rename, with fuel as an array under -fuel-format array.
+func projectAirport(ap Airport) any {
+	if !projecting() {
+		return ap
 	}
-	defer os.Remove(csvPath)
 
-	fmt.Println("[INFO] Parsing CSV:", csvPath)
+	// Airport always marshals; round-trip to pick keys by their JSON names.
+	b, _ := json.Marshal(ap)
+	var all map[string]json.RawMessage
+	json.Unmarshal(b, &all)
+	if *fuelFormat == "array" {
+		all["fuel"], _ = json.Marshal(ap.Fuel.List())
+	}
 
-	airports, err := parseAirports(csvPath)
-	if err != nil {
-		panic(err)
+	keys := selectedFields
+	if keys == nil {
+		keys = slices.Collect(maps.Keys(all))
 	}
 
-	os.MkdirAll("public", 0755)
+	out := make(map[string]json.RawMessage, len(keys))
+	for _, f := range keys {
+		if v, ok := all[f]; ok {
+			out[outputKey(f)] = v
+		}
+	}
+	return out
+}
 
-	err = writeJSON("public/airports.json", airports)
-	if err != nil {
-		panic(err)
+func projectAirports(airports []Airport) any {
+	if !projecting() {
+		return airports
 	}
 
-	fmt.Println("[INFO] NASR update completed successfully.")
+	out := make([]any, len(airports))
+	for i, ap := range airports {
+		out[i] = projectAirport(ap)
+	}
+	return out
 }
 
 //
 // -----------------------------------------------------------------------------
-// ZIP EXTRACTION
+// JSON OUTPUT
 // -----------------------------------------------------------------------------
 
-func extractCSV(zipPath string) (string, error) {
-	r, err := zip.OpenReader(zipPath)
+// writeJSON writes v as indented JSON. Output is byte-for-byte reproducible:
+// structs (Fuel included) marshal in field order, encoding/json sorts map keys,
+// and datasets are sorted before writing. Only the generated timestamps in
+// meta.json, changes.json and status.json differ between runs.
+func writeJSON(path string, v any) error {
+	return writeStream(path, func(w io.Writer) error { return encodeJSON(w, v) })
+}
+
+// encodeJSON writes v as json.MarshalIndent(v, "", "  ") would, byte for
+// byte. Airport lists are encoded one element at a time, so only a single
+// airport is ever marshaled in memory; json.Encoder would buffer the whole
+// value before writing it.
+func encodeJSON(w io.Writer, v any) error {
+	switch v := v.(type) {
+	case []Airport:
+		return encodeJSONArray(w, v)
+	case []NearResult:
+		return encodeJSONArray(w, v)
+	case []any:
+		return encodeJSONArray(w, v)
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer r.Close()
+	_, err = w.Write(b)
+	return err
+}
 
-	for _, f := range r.File {
-		if strings.EqualFold(f.Name, "APT_BASE.csv") {
-			rc, err := f.Open()
-			if err != nil {
-				return "", err
-			}
-			defer rc.Close()
+// encodeJSONArray is encodeJSON for a slice: each element is indented one
+// level in, as it would be inside the marshaled slice.
+func encodeJSONArray[T any](w io.Writer, items []T) error {
+	if items == nil {
+		_, err := io.WriteString(w, "null")
+		return err
+	}
+	if len(items) == 0 {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
 
-			outName := "APT_BASE.csv"
-			out, err := os.Create(outName)
-			if err != nil {
-				return "", err
-			}
-			defer out.Close()
+	sep := "[\n  "
+	for _, item := range items {
+		b, err := json.MarshalIndent(item, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		sep = ",\n  "
+	}
+	_, err := io.WriteString(w, "\n]")
+	return err
+}
 
-			_, err = io.Copy(out, rc)
-			return outName, err
+// writeStream is writeOutput for output produced by encode. A local file
+// that nothing else needs in memory (no -bundle or -compress) is encoded
+// straight into it; otherwise the output is buffered and handed to
+// writeOutput.
+func writeStream(path string, encode func(io.Writer) error) error {
+	if bundled != nil || *compress != "" || strings.Contains(path, "://") {
+		var buf bytes.Buffer
+		if err := encode(&buf); err != nil {
+			return err
 		}
+		return writeOutput(path, buf.Bytes())
 	}
 
-	return "", fmt.Errorf("APT_BASE.csv not found in ZIP")
+	h := sha256.New()
+	var size int64
+	err := writeLocal(path, func(w io.Writer) error {
+		h.Reset()
+		cw := &countWriter{w: io.MultiWriter(w, h)}
+		err := encode(cw)
+		size = cw.n
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	recordManifest(path, int(size), h.Sum(nil))
+	return nil
 }
 
-//
-// -----------------------------------------------------------------------------
-// CSV PARSER
-// -----------------------------------------------------------------------------
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeOutput writes b to path plus the -compress copy, if any.
+func writeOutput(path string, b []byte) error {
+	addToBundle(path, b)
+	addToManifest(path, b)
+	err := writeFile(path, b)
+	if err != nil || *compress == "" {
+		return err
+	}
+	return writeCompressed(path, b)
+}
 
-func parseAirports(path string) ([]Airport, error) {
-	f, err := os.Open(path)
+// writeCompressed writes b next to path as path.gz or path.br so a CDN can
+// serve the pre-compressed encoding directly.
+func writeCompressed(path string, b []byte) error {
+	z, ext, err := compressBytes(b, *compress)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer f.Close()
+	addToManifest(path+ext, z)
+	return writeFile(path+ext, z)
+}
 
-	r := csv.NewReader(f)
-	r.FieldsPerRecord = -1
+// compressBytes compresses b at the best level with br, or otherwise gzip,
+// and returns the result with its file extension.
+func compressBytes(b []byte, encoding string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	ext := ".gz"
 
-	rows, err := r.ReadAll()
-	if err != nil {
-		return nil, err
+	switch encoding {
+	case "br":
+		zw = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		ext = ".br"
+	default:
+		zw, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
 	}
 
-	header := rows[0]
-	col := func(name string) int {
-		for i, h := range header {
-			if h == name {
-				return i
-			}
-		}
-		return -1
+	if _, err := zw.Write(b); err != nil {
+		return nil, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, "", err
 	}
+	return buf.Bytes(), ext, nil
+}
 
-	iID := col("ARPT_ID")
-	iLat := col("LAT_DECIMAL")
-	iLon := col("LONG_DECIMAL")
-	iName := col("ARPT_NAME")
-	iCity := col("CITY")
-	iState := col("STATE_CODE")
-	iFuel := col("FUEL_TYPES")
+// Permissions applied to every local output file, from -file-mode.
+var outputMode os.FileMode = 0644
 
-	var out []Airport
+// writeFile writes b to a local path or S3 URL. Local files are written to a
+// temporary file in the same directory and renamed into place, so readers
+// (a web server, a mounted volume) never see a partial file. Transient local
+// errors are retried a few times with backoff.
+func writeFile(path string, b []byte) error {
+	if strings.HasPrefix(path, "s3://") {
+		return uploadS3(path, b)
+	}
+	if strings.Contains(path, "://") {
+		return fmt.Errorf("unsupported output URL %q (want a local path or s3://)", path)
+	}
 
-	for _, row := range rows[1:] {
-		lat, _ := strconv.ParseFloat(row[iLat], 64)
-		lon, _ := strconv.ParseFloat(row[iLon], 64)
-		id := strings.TrimSpace(row[iID])
+	return writeLocal(path, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
 
-		ap := Airport{
-			ArptID: id,
-			Name:   row[iName],
-			City:   row[iCity],
-			State:  row[iState],
-			ICAO:   "K" + id,
-			Lat:    lat,
-			Lon:    lon,
-			Fuel:   parseFuel(row[iFuel]),
+// writeLocal runs writeLocalFile, retrying transient errors with backoff.
+// write must produce the same output on every attempt.
+func writeLocal(path string, write func(io.Writer) error) error {
+	delay := writeRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := writeLocalFile(path, write)
+		if err == nil || attempt == writeAttempts || !retryableWriteError(err) {
+			return err
 		}
+		logWarn(fmt.Sprintf("Write to %s failed (%v); retrying in %s (attempt %d of %d).",
+			path, err, delay, attempt+1, writeAttempts))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
 
-		out = append(out, ap)
+// Bounded retries for transient local write errors (see retryableWriteError).
+const (
+	writeAttempts   = 4
+	writeRetryDelay = 250 * time.Millisecond
+)
+
+// retryableWriteError reports errors that can clear on their own: a busy or
+// interrupted call, a stale NFS handle, or a full disk that may be freed
+// (the failed temporary file is removed before the retry). Anything else,
+// such as a permission error, fails immediately.
+func retryableWriteError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ENOSPC, syscall.ESTALE, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
 	}
+	return false
+}
 
-	return out, nil
+// writeLocalFile is one attempt of writeFile's temporary-file-and-rename.
+func writeLocalFile(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriter(tmp)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	// Chmod explicitly: CreateTemp uses 0600 and the umask would mask -file-mode.
+	if err := tmp.Chmod(outputMode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
 
-func parseFuel(s string) map[string]bool {
-	x := strings.ToUpper(s)
-	return map[string]bool{
-		"mogas": strings.Contains(x, "MOGAS"),
-		"100ll": strings.Contains(x, "100"),
-		"jet_a": strings.Contains(x, "JET"),
+// siblingPath returns name in -output-dir, or else in the same directory (or
+// S3 prefix) as -out.
+func siblingPath(name string) string {
+	if *outputDir != "" {
+		if strings.Contains(*outputDir, "://") {
+			return strings.TrimSuffix(*outputDir, "/") + "/" + name
+		}
+		return filepath.Join(*outputDir, name)
 	}
+	if strings.Contains(*outPath, "://") {
+		return (*outPath)[:strings.LastIndex(*outPath, "/")+1] + name
+	}
+	return filepath.Join(filepath.Dir(*outPath), name)
 }
 
-//
-// -----------------------------------------------------------------------------
-// JSON OUTPUT
-// -----------------------------------------------------------------------------
+// loadAirports reads a dataset written by this tool back into Airports. It
+// accepts a bare JSON array (the default output), NDJSON with one airport
+// object per line, and an envelope object holding the array under
+// "airports" (as in the -cache-dir parsed_<cycle>.json files). Keys an
+// Airport doesn't have, such as distance_nm, are ignored, and fuel may be in
+// either -fuel-format.
+func loadAirports(path string) ([]Airport, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-func writeJSON(path string, v any) error {
-	b, err := json.MarshalIndent(v, "", "  ")
+	out, err := decodeAirports(b)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+// decodeAirports is loadAirports on the file contents.
+func decodeAirports(b []byte) ([]Airport, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] == '[' {
+		var out []Airport
+		return out, json.Unmarshal(b, &out)
+	}
+
+	// An envelope is a single object with an "airports" array.
+	var env struct {
+		Airports *[]Airport `json:"airports"`
+	}
+	if json.Unmarshal(b, &env) == nil && env.Airports != nil {
+		return *env.Airports, nil
+	}
+
+	var out []Airport
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var ap Airport
+		if err := dec.Decode(&ap); err != nil {
+			return nil, err
+		}
+		out = append(out, ap)
 	}
-	return os.WriteFile(path, b, 0644)
+	return out, nil
 }
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// aptHeader is the APT_BASE.csv header used by the fixtures, in FAA's order.
+const aptHeader = "EFF_DATE,SITE_NO,SITE_TYPE_CODE,STATE_CODE,ARPT_ID,CITY,COUNTRY_CODE,REGION_CODE,ADO_CODE,STATE_NAME,COUNTY_NAME,COUNTY_ASSOC_STATE,ARPT_NAME,OWNERSHIP_TYPE_CODE,FACILITY_USE_CODE,LAT_DECIMAL,LONG_DECIMAL,ELEV,FUEL_TYPES,TWR_TYPE_CODE,ICAO_ID"
+
+// syntheticAPTBase returns an APT_BASE.csv of n airports spread over the
+// contiguous U.S., with a realistic mix of fuel codes: about the size of a
+// real cycle at n = 20000.
+func syntheticAPTBase(n int) string {
+	fuels := []string{"", "100LL", "100LL,A", "\"100LL,MOGAS\"", "A", "\"100LL,A,A+\"", "MOGAS", ""}
+
+	var b strings.Builder
+	b.WriteString(aptHeader + "\n")
+	for i := range n {
+		lat := 25 + float64(i%2400)/100
+		lon := -124 + float64(i%5700)/100
+		fmt.Fprintf(&b, "2026/10/01,%d.A,A,CA,X%04d,SOMETOWN,US,AWP,SFO,CALIFORNIA,SOME COUNTY,CA,SOMETOWN MUNI,PU,PU,%.6f,%.6f,100,%s,NON-ATCT,KX%03d\n",
+			i, i, lat, lon, fuels[i%len(fuels)], i%1000)
+	}
+	return b.String()
+}
+
+// mustReadCSV parses an inline CSV fixture.
+func mustReadCSV(tb testing.TB, s string) [][]string {
+	tb.Helper()
+	rows, err := readCSV("APT_BASE.csv", strings.NewReader(s))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return rows
+}
+
+// mustParse parses an APT_BASE.csv fixture.
+func mustParse(tb testing.TB, csv string) []Airport {
+	tb.Helper()
+	airports, err := parseAirports(mustReadCSV(tb, csv))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return airports
+}
+
+func BenchmarkParseAirports(b *testing.B) {
+	*quiet = true
+	csv := syntheticAPTBase(20000)
+	rows := mustReadCSV(b, csv)
+
+	b.SetBytes(int64(len(csv)))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := parseAirports(rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	*quiet = true
+	airports := mustParse(b, syntheticAPTBase(20000))
+
+	b.SetBytes(encodedSize(b, airports))
+	b.ResetTimer()
+	for range b.N {
+		if err := encodeJSON(io.Discard, airports); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	*quiet = true
+	airports := mustParse(b, syntheticAPTBase(20000))
+	path := filepath.Join(b.TempDir(), "airports.json")
+
+	b.SetBytes(encodedSize(b, airports))
+	b.ResetTimer()
+	for range b.N {
+		if err := writeJSON(path, airports); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// fuelList returns the fuels set in f, comma-separated in fuelTypes order.
+func fuelList(f Fuel) string {
+	var out []string
+	for _, key := range fuelTypes {
+		if f.Has(key) {
+			out = append(out, key)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// encodedSize returns the length of airports' JSON encoding.
+func encodedSize(tb testing.TB, airports []Airport) int64 {
+	tb.Helper()
+	cw := &countWriter{w: io.Discard}
+	if err := encodeJSON(cw, airports); err != nil {
+		tb.Fatal(err)
+	}
+	return cw.n
+}
+
+// FuzzParseAirports feeds arbitrary CSV to readCSV and parseAirports, which
+// must never panic and must return either airports or an error, not both.
+// The seeds in testdata/fuzz/FuzzParseAirports cover the shapes that used to
+// index out of range: short rows, a header without required columns, and
+// columns that resolve to -1.
+func FuzzParseAirports(f *testing.F) {
+	*quiet = true
+	f.Add(aptHeader + "\n2026/10/01,1.A,A,CA,PAO,PALO ALTO,US,AWP,SFO,CALIFORNIA,SANTA CLARA,CA,PALO ALTO,PU,PU,37.461111,-122.115056,6,\"100LL,MOGAS\",ATCT,KPAO\n")
+	f.Add("ARPT_ID,LAT_DECIMAL,LONG_DECIMAL,FUEL_TYPES\nPAO,37.4,-122.1,100LL\n\n,\nX\n")
+	f.Add("ARPT_ID\nPAO\n")
+
+	f.Fuzz(func(t *testing.T, in string) {
+		rows, err := readCSV("APT_BASE.csv", strings.NewReader(in))
+		if err != nil {
+			return
+		}
+		airports, err := parseAirports(rows)
+		if err != nil && airports != nil {
+			t.Fatalf("parseAirports returned %d airports with error %v", len(airports), err)
+		}
+	})
+}
+
+func TestParseFuel(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		// No recognized code yields the zero Fuel.
+		{"", ""},
+		{"   ", ""},
+		{"80", ""},
+		{"B", ""},
+		{"UL94", ""},
+		{"B,80,UL94", ""},
+		{"100UL", ""},
+
+		// Codes are split on commas, semicolons, and spaces, in any case.
+		{"100LL", "100ll"},
+		{"100ll", "100ll"},
+		{"100LL,A", "100ll,jet_a"},
+		{"100LL;A", "100ll,jet_a"},
+		{"100LL A MOGAS", "mogas,100ll,jet_a"},
+		{" 100LL , , A ", "100ll,jet_a"},
+		{"A,A,A", "jet_a"},
+
+		// Whole codes only: A inside another code isn't Jet A.
+		{"B+", ""},
+		{"ALL", ""},
+
+		// Failing an exact match, codes containing MOGAS are recognized.
+		// 100UL is unleaded, not 100LL, and other 100 codes aren't guessed at.
+		{"MOGAS91", "mogas"},
+		{"UNLEADEDMOGAS", "mogas"},
+		{"100LL,MOGAS91", "mogas,100ll"},
+		{"100LL,100UL", "100ll"},
+		{"100LLX", ""},
+
+		// Punctuation inside codes is removed before matching.
+		{"100/130", "100ll"},
+		{"100-LL", "100ll"},
+		{"JET-A", "jet_a"},
+		{"JET-A+", "jet_a"},
+		{"JET-A1+", "jet_a"},
+		{"A+", "jet_a"},
+		{"A++", "jet_a"},
+		{"100-LL JET-A+", "100ll,jet_a"},
+
+		// Synonyms map to the canonical flags.
+		{"MOGAS", "mogas"},
+		{"AUTO", "mogas"},
+		{"AUTOGAS", "mogas"},
+		{"AUTO FUEL", "mogas"},
+		{"auto fuel", "mogas"},
+		{"AVGAS", "100ll"},
+		{"100", "100ll"},
+		{"JET", "jet_a"},
+		{"A1", "jet_a"},
+		{"JETA1", "jet_a"},
+	}
+	for _, tt := range tests {
+		if got := fuelList(ParseFuel(tt.raw)); got != tt.want {
+			t.Errorf("ParseFuel(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// paoRow is a complete APT_BASE.csv row for the aptHeader fixtures.
+const paoRow = "2026/10/01,1.A,A,CA,PAO,PALO ALTO,US,AWP,SFO,CALIFORNIA,SANTA CLARA,CA,PALO ALTO,PU,PU,37.461111,-122.115056,6,\"100LL,MOGAS\",ATCT,KPAO"
+
+func TestParseAirportsTrailingBlankRows(t *testing.T) {
+	*quiet = true
+	csv := aptHeader + "\n" + paoRow + "\n\n,\n,,,,\n\r\n"
+
+	airports := mustParse(t, csv)
+	if len(airports) != 1 || airports[0].ArptID != "PAO" {
+		t.Fatalf("got %+v, want only PAO", airports)
+	}
+	if len(lastRejects) != 2 {
+		t.Fatalf("got %d rejects, want 2: %+v", len(lastRejects), lastRejects)
+	}
+	for _, r := range lastRejects {
+		if r.reason != "truncated row" {
+			t.Errorf("reject reason %q, want truncated row", r.reason)
+		}
+	}
+}
+
+func TestReadCSVLatin1(t *testing.T) {
+	*quiet = true
+	row := strings.Replace(paoRow, "PALO ALTO,PU", "SAN JOS\xc9,PU", 1)
+	airports := mustParse(t, aptHeader+"\n"+row+"\n")
+	if len(airports) != 1 || airports[0].Name != "SAN JOSÉ" {
+		t.Fatalf("got %+v, want one airport named SAN JOSÉ", airports)
+	}
+
+	out, err := json.Marshal(airports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.Valid(out) || !strings.Contains(string(out), `"name":"SAN JOSÉ"`) {
+		t.Errorf("JSON output isn't valid UTF-8 with the decoded name:\n%s", out)
+	}
+}
+
+// captureWarnings returns what fn logs with logWarn.
+func captureWarnings(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	fn()
+	w.Close()
+	b, _ := io.ReadAll(r)
+	return string(b)
+}
+
+func TestParseAirportsDuplicateHeader(t *testing.T) {
+	*quiet = true
+	// FUEL_TYPES appears twice; the second copy holds the real codes.
+	rows := mustReadCSV(t, aptHeader+",FUEL_TYPES\n"+paoRow+",\"100LL,A\"\n")
+
+	var airports []Airport
+	var err error
+	warnings := captureWarnings(t, func() { airports, err = parseAirports(rows) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "APT_BASE.csv: duplicate column FUEL_TYPES at indices [18 21]; using 18"; !strings.Contains(warnings, want) {
+		t.Errorf("warnings %q don't contain %q", warnings, want)
+	}
+	if got := fuelList(airports[0].Fuel); got != "mogas,100ll" {
+		t.Errorf("first FUEL_TYPES: got %q, want mogas,100ll", got)
+	}
+
+	// -column picks the other copy by index, without a warning.
+	columnOverrides = map[string]int{"APT_BASE.csv:FUEL_TYPES": 21}
+	defer func() { columnOverrides = nil }()
+	warnings = captureWarnings(t, func() { airports, err = parseAirports(rows) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(warnings, "duplicate column") {
+		t.Errorf("-column override still warned: %q", warnings)
+	}
+	if got := fuelList(airports[0].Fuel); got != "100ll,jet_a" {
+		t.Errorf("-column FUEL_TYPES=21: got %q, want 100ll,jet_a", got)
+	}
+}
+
+func TestDeriveICAO(t *testing.T) {
+	tests := []struct {
+		lid, state, want string
+	}{
+		{"PAO", "CA", "KPAO"},
+		{"SEA", "WA", "KSEA"},
+		{"BOS", " MA ", "KBOS"},
+		{"ANC", "AK", "PANC"},
+		{"FAI", "AK", ""}, // PAFA, not derivable
+		{"HNL", "HI", "PHNL"},
+		{"OGG", "HI", ""}, // PHOG
+		{"JSJ", "PR", "TJSJ"},
+		{"SJU", "PR", ""}, // TJSJ
+		{"ISX", "VI", "TISX"},
+		{"GUM", "GU", "PGUM"},
+		{"SPN", "MP", ""}, // PGSN
+		{"PPG", "AS", ""}, // NSTU
+		{"4CA", "CA", ""},
+		{"CA12", "CA", ""},
+		{"PA", "CA", ""},
+		{"pao", "CA", ""},
+	}
+	for _, tt := range tests {
+		if got := deriveICAO(tt.lid, tt.state); got != tt.want {
+			t.Errorf("deriveICAO(%q, %q) = %q, want %q", tt.lid, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestParseAirportsICAO(t *testing.T) {
+	*quiet = true
+	header := "ARPT_ID,STATE_CODE,LAT_DECIMAL,LONG_DECIMAL,FUEL_TYPES"
+	data := "ANC,AK,61.174,-149.998,A\nPAO,CA,37.461,-122.115,100LL\n"
+
+	// Without an ICAO_ID column the code is derived.
+	airports := mustParse(t, header+"\n"+data)
+	if airports[0].ICAO != "PANC" || airports[1].ICAO != "KPAO" {
+		t.Errorf("derived ICAO %q, %q; want PANC, KPAO", airports[0].ICAO, airports[1].ICAO)
+	}
+
+	// With the column, its value wins, and blank means none was assigned.
+	airports = mustParse(t, header+",ICAO_ID\n"+
+		"ANC,AK,61.174,-149.998,A,PANC\nPAO,CA,37.461,-122.115,100LL,\n")
+	if airports[0].ICAO != "PANC" || airports[1].ICAO != "" {
+		t.Errorf("ICAO_ID column gave %q, %q; want PANC and blank", airports[0].ICAO, airports[1].ICAO)
+	}
+}
+
+func TestSerializationDeterministic(t *testing.T) {
+	*quiet = true
+	csv := syntheticAPTBase(500)
+	dir := t.TempDir()
+
+	write := func(name string) []byte {
+		path := filepath.Join(dir, name)
+		if err := writeJSON(path, projectAirports(mustParse(t, csv))); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// -fields and -rename output marshals maps, whose iteration order is
+	// random.
+	defer func() { selectedFields, renames = nil, nil }()
+	for _, tt := range []struct {
+		name   string
+		fields []string
+		rename map[string]string
+	}{
+		{"struct", nil, nil},
+		{"fields", []string{"lon", "arpt_id", "fuel", "lat", "name"}, nil},
+		{"rename", nil, map[string]string{"lat": "latitude", "lon": "longitude"}},
+	} {
+		selectedFields, renames = tt.fields, tt.rename
+		first := write(tt.name + "_0.json")
+		for i := range 5 {
+			if !bytes.Equal(write(fmt.Sprintf("%s_%d.json", tt.name, i+1)), first) {
+				t.Fatalf("%s: two serializations of the same data differ", tt.name)
+			}
+		}
+	}
+}
+
+func TestEncodeJSONMatchesMarshalIndent(t *testing.T) {
+	*quiet = true
+	airports := mustParse(t, syntheticAPTBase(200))
+	for _, v := range []any{airports, []Airport{}, []Airport(nil), projectAirports(airports), Meta{Count: 1}} {
+		want, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if err := encodeJSON(&got, v); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("encodeJSON(%T) differs from json.MarshalIndent", v)
+		}
+	}
+}
+
+func TestLoadAirportsRoundTrip(t *testing.T) {
+	*quiet = true
+	airports := mustParse(t, syntheticAPTBase(50))
+	dir := t.TempDir()
+
+	ndjson := func() []byte {
+		var b bytes.Buffer
+		for _, ap := range airports {
+			line, _ := json.Marshal(ap)
+			b.Write(append(line, '\n'))
+		}
+		return b.Bytes()
+	}()
+
+	tests := []struct {
+		name  string
+		write func(path string) error
+	}{
+		{"array", func(path string) error { return writeJSON(path, airports) }},
+		{"fuel array", func(path string) error {
+			*fuelFormat = "array"
+			defer func() { *fuelFormat = "map" }()
+			return writeJSON(path, projectAirports(airports))
+		}},
+		{"envelope", func(path string) error {
+			return writeJSON(path, parsedCache{Options: parseOptions(), Airports: airports})
+		}},
+		{"ndjson", func(path string) error { return os.WriteFile(path, ndjson, 0o644) }},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".json")
+		if err := tt.write(path); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := loadAirports(path)
+		if err != nil {
+			t.Errorf("%s: loadAirports: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, airports) {
+			t.Errorf("%s: loadAirports returned different airports", tt.name)
+		}
+	}
+
+	if _, err := loadAirports(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loadAirports of a missing file succeeded")
+	}
+	path := filepath.Join(dir, "bad.json")
+	os.WriteFile(path, []byte(`[{"arpt_id": "PAO"`), 0o644)
+	if _, err := loadAirports(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("loadAirports of truncated JSON: err = %v, want an error naming the file", err)
+	}
+}
+
+func TestFirstDiff(t *testing.T) {
+	tests := []struct {
+		a, b       string
+		at         int
+		have, want string
+	}{
+		{`[{"id":"PAO"}]`, `[{"id":"PAO"}]`, -1, "", ""},
+		{`[{"fuel":["mogas"]}]`, `[{"fuel":{"mogas":true}}]`, 9, `["mogas"]}]`, `{"mogas":true}}]`},
+		{`[{"id":"PAO"}]`, `[{"id":"PAO"},{"id":"SQL"}]`, 13, "]", `,{"id":"SQL"}]`},
+	}
+	for _, tt := range tests {
+		at, have, want := firstDiff([]byte(tt.a), []byte(tt.b))
+		if at != tt.at || have != tt.have || want != tt.want {
+			t.Errorf("firstDiff(%s, %s) = %d, %q, %q; want %d, %q, %q", tt.a, tt.b, at, have, want, tt.at, tt.have, tt.want)
+		}
+	}
+}
+
+func TestParseAirportsGeocode(t *testing.T) {
+	*quiet, *geocode = true, true
+	t.Cleanup(func() { *geocode = false })
+
+	// Same city as PAO, so it gets PAO's position; nothing else is in Fresno.
+	nearPAO := strings.Replace(strings.Replace(paoRow, ",PAO,", ",CA12,", 1), "37.461111,-122.115056", ",", 1)
+	fresno := strings.Replace(strings.Replace(nearPAO, ",CA12,PALO ALTO,", ",CA34,FRESNO,", 1), ",PALO ALTO,PU,", ",FRESNO,PU,", 1)
+	csv := aptHeader + "\n" + paoRow + "\n" + nearPAO + "\n" + fresno + "\n"
+
+	airports := mustParse(t, csv)
+	if len(airports) != 2 || airports[1].ArptID != "CA12" {
+		t.Fatalf("got %+v, want PAO and CA12", airports)
+	}
+	if ap := airports[1]; !ap.ApproxLocation || ap.Lat != airports[0].Lat || ap.Lon != airports[0].Lon {
+		t.Errorf("CA12 at (%g, %g) approx=%t, want PAO's position, approximate", ap.Lat, ap.Lon, ap.ApproxLocation)
+	}
+	if len(lastRejects) != 1 || lastRejects[0].row[4] != "CA34" {
+		t.Fatalf("got rejects %+v, want only CA34", lastRejects)
+	}
+}
+
+func TestInUSBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     bool
+	}{
+		{"Palo Alto", 37.46, -122.12, true},
+		{"Key West", 24.56, -81.76, true},
+		{"Northwest Angle", 49.35, -95.07, true},
+		{"Anchorage", 61.17, -149.99, true},
+		{"Juneau", 58.35, -134.58, true},
+		{"Adak", 51.88, -176.65, true},
+		{"Shemya", 52.71, 174.11, true},
+		{"Honolulu", 21.32, -157.92, true},
+		{"Midway", 28.20, -177.38, true},
+		{"San Juan", 18.44, -66.00, true},
+		{"St. Croix", 17.70, -64.80, true},
+		{"Guam", 13.48, 144.80, true},
+		{"Wake Island", 19.28, 166.64, true},
+		{"Pago Pago", -14.33, -170.71, true},
+
+		{"Mexico City", 19.44, -99.07, false},
+		{"Calgary", 51.13, -114.02, false},
+		{"Whitehorse", 60.71, -135.07, false},
+		{"Havana", 22.99, -82.41, false},
+		{"Null Island", 0, 0, false},
+	}
+	for _, tt := range tests {
+		if got := inUSBounds(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("inUSBounds(%g, %g) (%s) = %t, want %t", tt.lat, tt.lon, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServeNeedsJSON(t *testing.T) {
+	saved := *format
+	t.Cleanup(func() { *format = saved })
+
+	for _, f := range []string{"csv", "kml"} {
+		*format = f
+		if err := runServer(); err == nil || !strings.Contains(err.Error(), "-format json") {
+			t.Errorf("-serve with -format %s: err = %v, want a -format error", f, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//
+// -----------------------------------------------------------------------------
+// FIXED-WIDTH NASR
+// -----------------------------------------------------------------------------
+
+// Before the CSV subscription, NASR shipped each table as fixed-width text.
+// -format-in fixed reads the APT records of such an archive's APT.txt, laid
+// out per the FAA's apt_rf.txt (1529-character records, the layout in use
+// from the 2010s until the CSV era). Each record is translated into an
+// APT_BASE.csv-shaped row, so parseAirports applies the same checks, rejects,
+// and -limit to both formats.
+
+const fixedAPTFile = "APT.txt"
+
+// fixedAPTFields are the APT record fields read, by APT_BASE.csv column.
+// start is the 1-based position given in the layout.
+var fixedAPTFields = []struct {
+	column        string
+	start, length int
+}{
+	{"SITE_TYPE_CODE", 15, 13},
+	{"ARPT_ID", 28, 4},
+	{"EFF_DATE", 32, 10},
+	{"REGION_CODE", 42, 3},
+	{"ADO_CODE", 45, 4},
+	{"STATE_CODE", 49, 2},
+	{"CITY", 94, 40},
+	{"ARPT_NAME", 134, 50},
+	{"OWNERSHIP_TYPE_CODE", 184, 2},
+	{"LAT_DECIMAL", 539, 12},
+	{"LONG_DECIMAL", 566, 12},
+	{"FUEL_TYPES", 901, 40},
+	{"TWR_TYPE_CODE", 981, 1},
+	{"ICAO_ID", 1211, 7},
+}
+
+// Landing facility types as spelled out in the fixed-width layout.
+var fixedSiteTypes = map[string]string{
+	"AIRPORT":       "A",
+	"BALLOONPORT":   "B",
+	"SEAPLANE BASE": "C",
+	"GLIDERPORT":    "G",
+	"HELIPORT":      "H",
+	"ULTRALIGHT":    "U",
+}
+
+// baseTable is the airport table -format-in reads, for messages.
+func baseTable() string {
+	if *formatIn == "fixed" {
+		return fixedAPTFile
+	}
+	return "APT_BASE.csv"
+}
+
+// loadBaseTable reads the airport table in the -format-in format.
+func loadBaseTable(zr *zip.Reader) ([][]string, error) {
+	if *formatIn == "fixed" {
+		return loadFixedAPT(zr)
+	}
+	return loadTable(zr, "APT_BASE.csv")
+}
+
+// loadFixedAPT reads APT.txt from the ZIP as APT_BASE.csv-shaped rows.
+func loadFixedAPT(zr *zip.Reader) ([][]string, error) {
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, fixedAPTFile) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return readFixedAPT(rc)
+	}
+	return nil, fmt.Errorf("%s %w", fixedAPTFile, errNotInZip)
+}
+
+// readFixedAPT converts the APT records in r; the other record types (ATT,
+// RWY, RMK, ARS) are skipped.
+func readFixedAPT(r io.Reader) ([][]string, error) {
+	header := make([]string, len(fixedAPTFields))
+	for i, fld := range fixedAPTFields {
+		header[i] = fld.column
+	}
+	rows := [][]string{header}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "APT") {
+			continue
+		}
+		if !utf8.ValidString(line) {
+			line = latin1ToUTF8(line)
+		}
+
+		row := make([]string, len(fixedAPTFields))
+		for i, fld := range fixedAPTFields {
+			row[i] = fixedValue(fld.column, fixedSlice(line, fld.start, fld.length))
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		return rows, fmt.Errorf("%s: %w", fixedAPTFile, err)
+	}
+	if len(rows) == 1 {
+		return nil, fmt.Errorf("%s: no APT records", fixedAPTFile)
+	}
+	logInfo(fmt.Sprintf("%s: read %d APT records.", fixedAPTFile, len(rows)-1))
+	return rows, nil
+}
+
+// fixedSlice returns the trimmed field at the 1-based start, or "" when the
+// line is too short to hold it.
+func fixedSlice(line string, start, length int) string {
+	from := start - 1
+	if from >= len(line) {
+		return ""
+	}
+	to := min(from+length, len(line))
+	return strings.TrimSpace(line[from:to])
+}
+
+// fixedValue rewrites a fixed-width value in its APT_BASE.csv form.
+func fixedValue(column, v string) string {
+	switch column {
+	case "SITE_TYPE_CODE":
+		if code, ok := fixedSiteTypes[v]; ok {
+			return code
+		}
+	case "EFF_DATE":
+		// MM/DD/YYYY, where APT_BASE.csv has YYYY/MM/DD.
+		if t, err := time.Parse("01/02/2006", v); err == nil {
+			return t.Format("2006/01/02")
+		}
+	case "LAT_DECIMAL", "LONG_DECIMAL":
+		return fixedSeconds(v)
+	case "FUEL_TYPES":
+		// Up to eight left-justified 5-character codes, e.g. "100LLA    ".
+		var codes []string
+		for i := 0; i < len(v); i += 5 {
+			if c := strings.TrimSpace(v[i:min(i+5, len(v))]); c != "" {
+				codes = append(codes, c)
+			}
+		}
+		return strings.Join(codes, ",")
+	case "TWR_TYPE_CODE":
+		switch v {
+		case "Y":
+			return "ATCT"
+		case "N":
+			return "NON-ATCT"
+		}
+	}
+	return v
+}
+
+// fixedSeconds converts total arc-seconds with a hemisphere suffix
+// ("134860.5000N", "442433.7740W") to signed decimal degrees. Malformed
+// values become "", which parseAirports treats as missing coordinates.
+func fixedSeconds(v string) string {
+	if len(v) < 2 {
+		return ""
+	}
+	secs, err := strconv.ParseFloat(v[:len(v)-1], 64)
+	if err != nil {
+		return ""
+	}
+	deg := secs / 3600
+	switch v[len(v)-1] {
+	case 'N', 'E':
+	case 'S', 'W':
+		deg = -deg
+	default:
+		return ""
+	}
+	return strconv.FormatFloat(deg, 'f', 6, 64)
+}
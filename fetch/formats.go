@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// OUTPUT WRITERS
+// -----------------------------------------------------------------------------
+
+// Writer encodes the dataset in one output format. It is given the filtered,
+// enriched airports sorted by ID.
+type Writer interface {
+	Write(airports []Airport, w io.Writer) error
+}
+
+// registeredWriter is a Writer and the file name it writes by default, next
+// to -out, when -out isn't given.
+type registeredWriter struct {
+	Writer
+	file string
+}
+
+// writers maps each -format name to its Writer.
+var writers = map[string]registeredWriter{}
+
+// RegisterWriter makes w available as -format name. file is the default
+// output file name; "" keeps -out's default (airports.json). Registering a
+// name twice replaces the earlier writer.
+func RegisterWriter(name, file string, w Writer) {
+	writers[name] = registeredWriter{w, file}
+}
+
+func init() {
+	RegisterWriter("json", "", jsonWriter{})
+	RegisterWriter("overlay", "overlay.json", overlayWriter{})
+	RegisterWriter("kml", "airports.kml", kmlWriter{})
+	RegisterWriter("csv", "airports.csv", csvWriter{})
+}
+
+// writerNames lists the registered formats, sorted, for help and errors.
+func writerNames() []string {
+	names := make([]string, 0, len(writers))
+	for name := range writers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writeFormat encodes airports with the -format writer and writes the result
+// to path like any other output (atomic, -compress, -bundle). The writer
+// streams into the file when writeStream allows it.
+func writeFormat(path string, airports []Airport) error {
+	return writeStream(path, func(w io.Writer) error { return writers[*format].Write(airports, w) })
+}
+
+//
+// -----------------------------------------------------------------------------
+// JSON OUTPUT
+// -----------------------------------------------------------------------------
+
+// jsonWriter writes the default dataset: indented JSON of projected airports,
+// sorted by distance with a distance key under -home.
+type jsonWriter struct{}
+
+func (jsonWriter) Write(airports []Airport, w io.Writer) error {
+	var v any = projectAirports(airports)
+	if *home != "" {
+		v = projectNear(sortByDistance(airports, homeLat, homeLon))
+	}
+	return encodeJSON(w, v)
+}
+
+//
+// -----------------------------------------------------------------------------
+// OVERLAY OUTPUT
+// -----------------------------------------------------------------------------
+
+// Fuel bits in the overlay format's fuel bitmask.
+const (
+	fuelBitMoGas = 1 << iota // bit 0
+	fuelBit100LL             // bit 1
+	fuelBitJetA              // bit 2
+)
+
+// FuelBits packs the fuel flags into the overlay bitmask.
+func (f Fuel) FuelBits() int {
+	bits := 0
+	if f.MoGas {
+		bits |= fuelBitMoGas
+	}
+	if f.Avgas100LL {
+		bits |= fuelBit100LL
+	}
+	if f.JetA {
+		bits |= fuelBitJetA
+	}
+	return bits
+}
+
+// overlayWriter writes the minimal moving-map format: compact JSON of
+// [id, lat, lon, fuelBits] arrays with coordinates rounded to 5 decimals
+// (about 1 m).
+type overlayWriter struct{}
+
+func (overlayWriter) Write(airports []Airport, w io.Writer) error {
+	round := func(v float64) float64 { return math.Round(v*1e5) / 1e5 }
+
+	records := make([][]any, len(airports))
+	for i, ap := range airports {
+		records[i] = []any{ap.ArptID, round(ap.Lat), round(ap.Lon), ap.Fuel.FuelBits()}
+	}
+
+	b, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+//
+// -----------------------------------------------------------------------------
+// CSV OUTPUT
+// -----------------------------------------------------------------------------
+
+// csvWriter writes one row per airport for spreadsheets. Fuel is one
+// true/false column per fuelTypes key, or with -flatten-fuel a single "fuel"
+// column of the available fuels joined with "|" ("MOGAS|100LL").
+type csvWriter struct{}
+
+func (csvWriter) Write(airports []Airport, w io.Writer) error {
+	header := []string{"arpt_id", "name", "city", "state", "icao", "type", "lat", "lon"}
+	if *flattenFuel {
+		header = append(header, "fuel")
+	} else {
+		header = append(header, fuelTypes...)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, ap := range airports {
+		row := []string{
+			ap.ArptID, ap.Name, ap.City, ap.State, ap.ICAO, ap.Type,
+			strconv.FormatFloat(ap.Lat, 'f', -1, 64),
+			strconv.FormatFloat(ap.Lon, 'f', -1, 64),
+		}
+		if *flattenFuel {
+			var fuels []string
+			for _, f := range fuelTypes {
+				if ap.Fuel.Has(f) {
+					fuels = append(fuels, strings.ToUpper(f))
+				}
+			}
+			row = append(row, strings.Join(fuels, "|"))
+		} else {
+			for _, f := range fuelTypes {
+				row = append(row, strconv.FormatBool(ap.Fuel.Has(f)))
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//
+// -----------------------------------------------------------------------------
+// KML OUTPUT
+// -----------------------------------------------------------------------------
+
+// Display names for fuelTypes keys in KML descriptions.
+var fuelLabels = map[string]string{"mogas": "MoGas", "100ll": "100LL", "jet_a": "Jet A"}
+
+type kmlFile struct {
+	XMLName  xml.Name    `xml:"kml"`
+	NS       string      `xml:"xmlns,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+// kmlWriter writes a KML document with one Placemark per airport for Google
+// Earth. The placemark is named by ICAO (LID when there is none); the
+// description (HTML, as Google Earth renders it) carries the airport name,
+// city, and fuels. KML coordinates are lon,lat,altitude.
+type kmlWriter struct{}
+
+func (kmlWriter) Write(airports []Airport, w io.Writer) error {
+	doc := kmlFile{NS: "http://www.opengis.net/kml/2.2", Document: kmlDocument{Name: "Airports"}}
+	for _, ap := range airports {
+		var fuels []string
+		for _, f := range fuelTypes {
+			if ap.Fuel.Has(f) {
+				fuels = append(fuels, fuelLabels[f])
+			}
+		}
+		fuel := "none listed"
+		if len(fuels) > 0 {
+			fuel = strings.Join(fuels, ", ")
+		}
+
+		name := ap.ICAO
+		if name == "" {
+			name = ap.ArptID
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:        name,
+			Description: html.EscapeString(ap.Name) + "<br>" + html.EscapeString(ap.City+", "+ap.State) + "<br>Fuel: " + fuel,
+			Coordinates: fmt.Sprintf("%g,%g,0", ap.Lon, ap.Lat),
+		})
+	}
+
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append([]byte(xml.Header), b...))
+	return err
+}
+
+//
+// -----------------------------------------------------------------------------
+// GEOJSON
+// -----------------------------------------------------------------------------
+
+// geoFeature is one airport as a GeoJSON Point feature. Properties are flat
+// (fuel flags at the top level) so vector-tile tooling can filter on them.
+type geoFeature struct {
+	Type       string         `json:"type"`
+	Geometry   geoPoint       `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // lon, lat
+}
+
+type geoCollection struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+// toGeoFeature converts an airport to a GeoJSON feature.
+func toGeoFeature(ap Airport) geoFeature {
+	props := map[string]any{
+		"arpt_id": ap.ArptID,
+		"name":    ap.Name,
+		"city":    ap.City,
+		"state":   ap.State,
+		"icao":    ap.ICAO,
+		"type":    ap.Type,
+	}
+	for _, f := range fuelTypes {
+		props[f] = ap.Fuel.Has(f)
+	}
+	return geoFeature{
+		Type:       "Feature",
+		Geometry:   geoPoint{Type: "Point", Coordinates: [2]float64{ap.Lon, ap.Lat}},
+		Properties: props,
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	_ "embed"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// FUEL KEYWORDS
+// -----------------------------------------------------------------------------
+
+// Fuel key that makes a keyword match nothing, e.g. to stop "*MOGAS*"
+// claiming a code that isn't mogas.
+const noFuel = "none"
+
+//go:embed fuel_keywords.txt
+var defaultFuelKeywords string
+
+// fuelKeywords maps normalized FUEL_TYPES codes (see fuelTokens) to fuel keys.
+type fuelKeywords struct {
+	exact    map[string]string
+	patterns [][2]string // pattern, fuel; in match order
+}
+
+// keywords is what ParseFuel consults: the embedded defaults, overridden by
+// -fuel-map.
+var keywords = mustParseFuelKeywords(defaultFuelKeywords)
+
+func mustParseFuelKeywords(s string) fuelKeywords {
+	k, err := parseFuelKeywords("fuel_keywords.txt", strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// parseFuelKeywords reads "CODE fuel" lines; blank lines and # comments are
+// skipped.
+func parseFuelKeywords(name string, r io.Reader) (fuelKeywords, error) {
+	k := fuelKeywords{exact: map[string]string{}}
+	valid := append(slices.Clone(fuelTypes), noFuel)
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 2 || !slices.Contains(valid, f[1]) {
+			return k, fmt.Errorf("%s:%d: want \"CODE fuel\" with fuel one of %s", name, n, strings.Join(valid, ", "))
+		}
+
+		code := strings.ToUpper(f[0])
+		if !strings.Contains(code, "*") {
+			k.exact[code] = f[1]
+			continue
+		}
+		if _, err := path.Match(code, ""); err != nil {
+			return k, fmt.Errorf("%s:%d: bad pattern %q", name, n, f[0])
+		}
+		k.patterns = append(k.patterns, [2]string{code, f[1]})
+	}
+	return k, sc.Err()
+}
+
+// loadFuelKeywords merges the -fuel-map file over the defaults: its codes
+// replace default entries, and its patterns are tried before the default ones.
+func loadFuelKeywords(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	custom, err := parseFuelKeywords(file, f)
+	if err != nil {
+		return err
+	}
+
+	for code, fuel := range custom.exact {
+		keywords.exact[code] = fuel
+	}
+	keywords.patterns = append(custom.patterns, keywords.patterns...)
+	return nil
+}
+
+// lookup returns the fuel key for a normalized code, or "" if none applies.
+func (k fuelKeywords) lookup(code string) string {
+	fuel, _ := k.match(code)
+	if fuel == noFuel {
+		return ""
+	}
+	return fuel
+}
+
+// match returns the fuel key of the first entry matching code, and whether
+// any did; a code mapped to none is matched.
+func (k fuelKeywords) match(code string) (string, bool) {
+	if fuel, ok := k.exact[code]; ok {
+		return fuel, true
+	}
+	for _, p := range k.patterns {
+		if m, _ := path.Match(p[0], code); m {
+			return p[1], true
+		}
+	}
+	return "", false
+}
+
+// printUnknownFuel prints, most frequent first, the FUEL_TYPES codes no
+// keyword matches, with the number of airports listing each, so the table
+// can be extended from real data. Codes mapped to none are known and left
+// out.
+func printUnknownFuel(rows [][]string) error {
+	err := requireColumns("APT_BASE.csv", rows[0], "ARPT_ID", "FUEL_TYPES")
+	if err != nil {
+		return err
+	}
+
+	col := columnLookup("APT_BASE.csv", rows[0])
+	iFuel := col("FUEL_TYPES")
+	data, _ := dataRows(rows, col("ARPT_ID"), iFuel)
+
+	counts := map[string]int{}
+	for _, row := range data {
+		seen := map[string]bool{}
+		for _, tok := range fuelTokens(field(row, iFuel)) {
+			if _, ok := keywords.match(tok); !ok && !seen[tok] {
+				seen[tok] = true
+				counts[tok]++
+			}
+		}
+	}
+
+	codes := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(codes, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+
+	fmt.Printf("%d unrecognized fuel codes in %d airports\n", len(codes), len(data))
+	for _, code := range codes {
+		fmt.Printf("%7d  %s\n", counts[code], code)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// withFuelMap loads a -fuel-map file of the given contents for the rest of
+// the test, restoring the defaults afterwards.
+func withFuelMap(t *testing.T, contents string) error {
+	t.Helper()
+	saved := fuelKeywords{maps.Clone(keywords.exact), slices.Clone(keywords.patterns)}
+	t.Cleanup(func() { keywords = saved })
+
+	file := filepath.Join(t.TempDir(), "fuel-map.txt")
+	if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return loadFuelKeywords(file)
+}
+
+func TestFuelMapOverridesDefaults(t *testing.T) {
+	err := withFuelMap(t, `
+# local corrections
+80     mogas   # new code
+A      none    # ignore the default Jet A
+*91*   mogas
+*MOGAS9*  none  # tried before the default *MOGAS*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"80", "mogas"},
+		{"A", ""},
+		{"10091", "mogas"},
+		{"UL91", "mogas"},
+		{"MOGAS93", ""},
+
+		// Defaults the file doesn't mention still apply.
+		{"100LL,JET-A", "100ll,jet_a"},
+		{"UNLEADEDMOGAS", "mogas"},
+		{"100UL", ""},
+	}
+	for _, tt := range tests {
+		if got := fuelList(ParseFuel(tt.raw)); got != tt.want {
+			t.Errorf("ParseFuel(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestFuelMapInvalid(t *testing.T) {
+	for _, contents := range []string{
+		"80\n",
+		"80 diesel\n",
+		"80 mogas extra\n",
+		"[A* mogas\n",
+	} {
+		if err := withFuelMap(t, contents); err == nil {
+			t.Errorf("-fuel-map %q loaded without error", contents)
+		}
+	}
+	if got := fuelList(ParseFuel("A")); got != "jet_a" {
+		t.Errorf("a failed -fuel-map changed the defaults: ParseFuel(\"A\") = %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// GEOCODING FALLBACK
+// -----------------------------------------------------------------------------
+
+// geocodeAirports approximates coordinates for airports that have none,
+// using the mean position of located airports in the same city and state.
+// It returns the placed airports and the indexes into missing of those with
+// no located airport in their city. Those are dropped rather than put at the
+// state centroid, which can be hundreds of miles off.
+func geocodeAirports(located, missing []Airport) ([]Airport, []int) {
+	type sum struct {
+		lat, lon float64
+		n        int
+	}
+	cities := map[string]*sum{}
+	for _, ap := range located {
+		k := cityKey(ap)
+		if cities[k] == nil {
+			cities[k] = &sum{}
+		}
+		cities[k].lat += ap.Lat
+		cities[k].lon += ap.Lon
+		cities[k].n++
+	}
+
+	var out []Airport
+	var unplaced []int
+	for i, ap := range missing {
+		c := cities[cityKey(ap)]
+		if c == nil {
+			unplaced = append(unplaced, i)
+			continue
+		}
+		ap.Lat, ap.Lon = c.lat/float64(c.n), c.lon/float64(c.n)
+		ap.ApproxLocation = true
+		out = append(out, ap)
+	}
+
+	logInfo(fmt.Sprintf("Approximated coordinates for %d of %d airports.", len(out), len(missing)))
+	if len(unplaced) > 0 {
+		logWarn(fmt.Sprintf("Skipped %d airports with no located airport in the same city to geocode from.", len(unplaced)))
+	}
+	return out, unplaced
+}
+
+func cityKey(ap Airport) string {
+	return strings.ToUpper(strings.TrimSpace(ap.City)) + "|" + strings.TrimSpace(ap.State)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+//
+// -----------------------------------------------------------------------------
+// FUEL HEATMAP
+// -----------------------------------------------------------------------------
+
+// Heatmap is heatmap.json: per-cell airport and fuel counts over a lat/lon
+// grid of CellDeg-degree cells, for spotting regions without a fuel.
+type Heatmap struct {
+	CellDeg float64       `json:"cell_deg"`
+	Cells   []HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell counts the airports in the cell whose south-west corner is
+// Lat, Lon, and how many of them offer each fuel.
+type HeatmapCell struct {
+	Lat      float64        `json:"lat"`
+	Lon      float64        `json:"lon"`
+	Airports int            `json:"airports"`
+	Fuel     map[string]int `json:"fuel"`
+}
+
+// buildHeatmap bins airports into cells of size degrees. Only cells with at
+// least one airport are listed, ordered south to north, then west to east.
+func buildHeatmap(airports []Airport, size float64) Heatmap {
+	type key struct{ row, col int }
+	cells := map[key]*HeatmapCell{}
+	for _, ap := range airports {
+		k := key{int(math.Floor(ap.Lat / size)), int(math.Floor(ap.Lon / size))}
+		c, ok := cells[k]
+		if !ok {
+			c = &HeatmapCell{Lat: cellEdge(k.row, size), Lon: cellEdge(k.col, size), Fuel: map[string]int{}}
+			for _, fuel := range fuelTypes {
+				c.Fuel[fuel] = 0
+			}
+			cells[k] = c
+		}
+		c.Airports++
+		for _, fuel := range fuelTypes {
+			if ap.Fuel.Has(fuel) {
+				c.Fuel[fuel]++
+			}
+		}
+	}
+
+	out := Heatmap{CellDeg: size, Cells: make([]HeatmapCell, 0, len(cells))}
+	for _, c := range cells {
+		out.Cells = append(out.Cells, *c)
+	}
+	slices.SortFunc(out.Cells, func(a, b HeatmapCell) int {
+		return cmp.Or(cmp.Compare(a.Lat, b.Lat), cmp.Compare(a.Lon, b.Lon))
+	})
+	return out
+}
+
+// cellEdge returns the coordinate where cell i starts, rounded so 0.1-degree
+// cells read 37.4 rather than 37.400000000000006.
+func cellEdge(i int, size float64) float64 {
+	return math.Round(float64(i)*size*1e6) / 1e6
+}
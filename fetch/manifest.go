@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// MANIFEST
+// -----------------------------------------------------------------------------
+
+// ManifestFile is one entry of manifest.json. Name is relative to the
+// manifest's directory when the file is inside it.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Files written by the current run, by path, for -manifest. nil when
+// -manifest isn't set.
+var manifested map[string]ManifestFile
+
+// startManifest resets the recorded files at the start of a pipeline run.
+func startManifest() {
+	if *manifest {
+		manifested = map[string]ManifestFile{}
+	}
+}
+
+// addToManifest records a written output file and its hash.
+func addToManifest(path string, b []byte) {
+	if manifested == nil {
+		return
+	}
+	sum := sha256.Sum256(b)
+	recordManifest(path, len(b), sum[:])
+}
+
+// recordManifest is addToManifest for a file hashed while it was written.
+func recordManifest(path string, size int, sum []byte) {
+	if manifested != nil {
+		manifested[path] = ManifestFile{Name: manifestName(path), Size: size, SHA256: hex.EncodeToString(sum)}
+	}
+}
+
+// manifestName returns path relative to the manifest's directory (or S3
+// prefix), or path unchanged when it is elsewhere.
+func manifestName(path string) string {
+	if rel, ok := outputRel(path); ok {
+		return rel
+	}
+	return path
+}
+
+// outputRel returns path relative to the output directory (or S3 prefix), in
+// slash form, and whether path is under it.
+func outputRel(path string) (string, bool) {
+	dir := siblingPath("")
+	if strings.Contains(dir, "://") {
+		return strings.CutPrefix(path, dir)
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// writeManifest writes manifest.json listing every recorded file, sorted by
+// name. It runs last, so it describes the final state of every other output.
+func writeManifest() error {
+	files := slices.Collect(maps.Values(manifested))
+	slices.SortFunc(files, func(a, b ManifestFile) int { return strings.Compare(a.Name, b.Name) })
+
+	b, err := json.MarshalIndent(struct {
+		Files []ManifestFile `json:"files"`
+	}{files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("Manifest lists %d files.", len(files)))
+	return writeFile(siblingPath("manifest.json"), b)
+}
+
+// finishOutputs writes the files that describe the run's other outputs: the
+// -bundle, then the -manifest, so the manifest covers the bundle too.
+func finishOutputs() error {
+	if *bundle != "" {
+		if err := writeBundle(*bundle); err != nil {
+			return err
+		}
+	}
+	if *manifest {
+		return writeManifest()
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// OURAIRPORTS CROSS-CHECK
+// -----------------------------------------------------------------------------
+
+// OurAirports publishes a community-maintained airports.csv; its U.S. rows
+// are an independent count of the facilities NASR should contain.
+const ourAirportsURL = "https://davidmegginson.github.io/ourairports-data/airports.csv"
+
+// ISO country codes OurAirports uses for the U.S. and its territories.
+var ourAirportsCountries = map[string]bool{"US": true, "PR": true, "VI": true, "GU": true, "AS": true, "MP": true, "UM": true}
+
+// Discrepancies beyond these are warned about (or fail under -strict).
+const (
+	maxCountDiff   = 0.15 // relative difference in airport counts
+	minIDsMatching = 0.85 // share of parsed LIDs OurAirports knows
+)
+
+// crossCheckOurAirports compares the parsed airports with OurAirports: the
+// number of open U.S. facilities, and the share of parsed LIDs it lists.
+// The check is advisory; if OurAirports can't be fetched it is skipped with
+// a warning.
+func crossCheckOurAirports(airports []Airport) error {
+	count, ids, err := loadOurAirports(*ourAirportsSrc)
+	if err != nil {
+		logWarn("OurAirports cross-check skipped:", err)
+		return nil
+	}
+
+	matched := 0
+	for _, ap := range airports {
+		if ids[ap.ArptID] || ap.ICAO != "" && ids[ap.ICAO] {
+			matched++
+		}
+	}
+
+	var problems []string
+	diff := math.Abs(float64(len(airports)-count)) / float64(max(count, 1))
+	if diff > maxCountDiff {
+		problems = append(problems, fmt.Sprintf("parsed %d airports but OurAirports lists %d U.S. facilities (%.0f%% apart)",
+			len(airports), count, diff*100))
+	}
+	share := float64(matched) / float64(max(len(airports), 1))
+	if share < minIDsMatching {
+		problems = append(problems, fmt.Sprintf("only %.0f%% of parsed IDs are known to OurAirports", share*100))
+	}
+
+	if len(problems) == 0 {
+		logInfo(fmt.Sprintf("OurAirports cross-check passed: %d parsed vs %d listed, %.0f%% of IDs matched.",
+			len(airports), count, share*100))
+		return nil
+	}
+	msg := "OurAirports cross-check: " + strings.Join(problems, "; ")
+	if *strict {
+		return errors.New(msg)
+	}
+	logWarn(msg)
+	return nil
+}
+
+// loadOurAirports reads an OurAirports airports.csv from a URL or local
+// path. It returns the number of open U.S. facilities and the set of their
+// identifiers (FAA local code, ident, GPS code).
+func loadOurAirports(src string) (int, map[string]bool, error) {
+	var r io.Reader
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := httpClient.Get(src)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, nil, fmt.Errorf("%s: %s", src, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	cr := csv.NewReader(r)
+	cr.LazyQuotes = true
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", src, err)
+	}
+	if len(rows) == 0 {
+		return 0, nil, fmt.Errorf("%s: empty file", src)
+	}
+
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	for _, name := range []string{"type", "iso_country", "ident", "local_code", "gps_code"} {
+		if _, ok := col[name]; !ok {
+			return 0, nil, fmt.Errorf("%s: no %s column; not an OurAirports airports.csv?", src, name)
+		}
+	}
+
+	count := 0
+	ids := map[string]bool{}
+	for _, row := range rows[1:] {
+		if !ourAirportsCountries[field(row, col["iso_country"])] || field(row, col["type"]) == "closed" {
+			continue
+		}
+		count++
+		for _, c := range []string{"local_code", "ident", "gps_code"} {
+			if id := strings.ToUpper(strings.TrimSpace(field(row, col[c]))); id != "" {
+				ids[id] = true
+			}
+		}
+	}
+	return count, ids, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// NEAREST-AIRPORT QUERY
+// -----------------------------------------------------------------------------
+
+const earthRadiusNM = 3440.065
+
+// One nautical mile in each -unit.
+var unitsPerNM = map[string]float64{
+	"nm": 1,
+	"sm": 1.150779,
+	"km": 1.852,
+}
+
+// NearResult is an airport annotated with its distance from the query point.
+type NearResult struct {
+	Airport
+	DistanceNM float64 `json:"distance_nm"`
+}
+
+// DistanceNM returns the great-circle distance in nautical miles.
+func DistanceNM(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusNM * math.Asin(math.Sqrt(a))
+}
+
+// parseLatLon parses a "lat,lon" flag value.
+func parseLatLon(s string) (lat, lon float64, err error) {
+	a, b, ok := strings.Cut(s, ",")
+	if ok {
+		lat, err = strconv.ParseFloat(strings.TrimSpace(a), 64)
+	}
+	if ok && err == nil {
+		lon, err = strconv.ParseFloat(strings.TrimSpace(b), 64)
+	}
+	if !ok || err != nil || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return 0, 0, fmt.Errorf("invalid coordinates %q (want lat,lon)", s)
+	}
+	return lat, lon, nil
+}
+
+// -home point, parsed in run.
+var homeLat, homeLon float64
+
+// A -bases entry.
+type basePoint struct{ lat, lon float64 }
+
+// -bases points, parsed in run.
+var homeBases []basePoint
+
+// parseBases parses a "lat,lon;lat,lon;..." flag value. Empty entries, as
+// from a trailing semicolon, are ignored.
+func parseBases(s string) ([]basePoint, error) {
+	var out []basePoint
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		lat, lon, err := parseLatLon(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, basePoint{lat, lon})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no bases in %q", s)
+	}
+	return out, nil
+}
+
+// addNearestBases sets NearestBase and NearestBaseNM on every airport. Ties
+// go to the base listed first.
+func addNearestBases(airports []Airport, bases []basePoint) {
+	for i := range airports {
+		best, bestNM := 0, math.Inf(1)
+		for j, b := range bases {
+			if d := DistanceNM(b.lat, b.lon, airports[i].Lat, airports[i].Lon); d < bestNM {
+				best, bestNM = j, d
+			}
+		}
+		airports[i].NearestBase = &best
+		airports[i].NearestBaseNM = &bestNM
+	}
+}
+
+// sortByDistance returns airports ordered by distance from lat,lon. Ties
+// break on ARPT_ID so the order is stable across runs and pages.
+func sortByDistance(airports []Airport, lat, lon float64) []NearResult {
+	out := make([]NearResult, len(airports))
+	for i, ap := range airports {
+		out[i] = NearResult{ap, DistanceNM(lat, lon, ap.Lat, ap.Lon)}
+	}
+	slices.SortFunc(out, func(a, b NearResult) int {
+		return cmp.Or(cmp.Compare(a.DistanceNM, b.DistanceNM), strings.Compare(a.ArptID, b.ArptID))
+	})
+	return out
+}
+
+// projectNear applies -fields and -rename to each result, always keeping the
+// distance, which is named for its -unit (distance_nm, distance_sm, or
+// distance_km).
+func projectNear(results []NearResult) any {
+	if !projecting() && *unit == "nm" {
+		return results
+	}
+
+	key := outputKey("distance_" + *unit)
+	out := make([]any, len(results))
+	for i, r := range results {
+		m, ok := projectAirport(r.Airport).(map[string]json.RawMessage)
+		if !ok {
+			b, _ := json.Marshal(r.Airport)
+			json.Unmarshal(b, &m)
+		}
+		m[key], _ = json.Marshal(r.DistanceNM * unitsPerNM[*unit])
+		out[i] = m
+	}
+	return out
+}
+
+// queryNear prints one page of the existing dataset sorted by distance from
+// the -near point as JSON: -offset results are skipped, then up to
+// -max-results are printed.
+func queryNear(point string) error {
+	lat, lon, err := parseLatLon(point)
+	if err != nil {
+		return err
+	}
+	if *offset < 0 || *maxResults < 0 {
+		return fmt.Errorf("-offset and -max-results must not be negative")
+	}
+
+	airports, err := loadAirports(*outPath)
+	if err != nil {
+		return err
+	}
+	if wantFuels != nil {
+		airports = filterByFuels(airports, wantFuels)
+	}
+	if excludeFuels != nil {
+		airports = excludeByFuels(airports, excludeFuels)
+	}
+
+	results := sortByDistance(airports, lat, lon)
+	start := min(*offset, len(results))
+	end := min(start+*maxResults, len(results))
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(projectNear(results[start:end]))
+}
+
+//
+// -----------------------------------------------------------------------------
+// NAME SEARCH
+// -----------------------------------------------------------------------------
+
+// searchRank orders a match: an exact name first, then names starting with
+// the query, names containing it, and last airports matched only by city. It
+// returns -1 for no match. query must be uppercase; NASR names are.
+func searchRank(ap Airport, query string) int {
+	name := strings.ToUpper(ap.Name)
+	switch {
+	case name == query:
+		return 0
+	case strings.HasPrefix(name, query):
+		return 1
+	case strings.Contains(name, query):
+		return 2
+	case strings.Contains(strings.ToUpper(ap.City), query):
+		return 3
+	}
+	return -1
+}
+
+// searchAirports prints the existing airports whose name or city contains
+// text, case-insensitively, best matches first and then alphabetically by
+// name. -fuel, -offset and -max-results apply as for -near.
+func searchAirports(text string) error {
+	query := strings.ToUpper(strings.TrimSpace(text))
+	if *offset < 0 || *maxResults < 0 {
+		return fmt.Errorf("-offset and -max-results must not be negative")
+	}
+
+	airports, err := loadAirports(*outPath)
+	if err != nil {
+		return err
+	}
+	if wantFuels != nil {
+		airports = filterByFuels(airports, wantFuels)
+	}
+	if excludeFuels != nil {
+		airports = excludeByFuels(airports, excludeFuels)
+	}
+
+	rank := map[string]int{}
+	matches := []Airport{}
+	for _, ap := range airports {
+		if r := searchRank(ap, query); r >= 0 {
+			rank[ap.ArptID] = r
+			matches = append(matches, ap)
+		}
+	}
+	slices.SortFunc(matches, func(a, b Airport) int {
+		return cmp.Or(cmp.Compare(rank[a.ArptID], rank[b.ArptID]),
+			strings.Compare(a.Name, b.Name), strings.Compare(a.ArptID, b.ArptID))
+	})
+
+	start := min(*offset, len(matches))
+	end := min(start+*maxResults, len(matches))
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(projectAirports(matches[start:end]))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// REJECTED ROWS
+// -----------------------------------------------------------------------------
+
+// rejectedRow is a raw APT_BASE.csv row the parser skipped, and why.
+type rejectedRow struct {
+	reason string
+	row    []string
+}
+
+// The rows the last parseAirports call skipped, and the header they are
+// keyed by, for -rejects.
+var (
+	rejectsHeader []string
+	lastRejects   []rejectedRow
+)
+
+// writeRejects writes rejected rows for review: as CSV (a "reason" column
+// followed by the original columns) when path ends in .csv, otherwise as a
+// JSON array of {"reason", "row"} objects with row keyed by column name.
+func writeRejects(path string, header []string, rejects []rejectedRow) error {
+	logInfo(fmt.Sprintf("Writing %d rejected rows to %s", len(rejects), path))
+
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		w.Write(append([]string{"reason"}, header...))
+		for _, r := range rejects {
+			w.Write(append([]string{r.reason}, r.row...))
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		return writeFile(path, b.Bytes())
+	}
+
+	type record struct {
+		Reason string            `json:"reason"`
+		Row    map[string]string `json:"row"`
+	}
+	out := make([]record, len(rejects))
+	for i, r := range rejects {
+		row := make(map[string]string, len(r.row))
+		for j, v := range r.row {
+			if j < len(header) {
+				row[header[j]] = v
+			}
+		}
+		out[i] = record{r.reason, row}
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, b)
+}
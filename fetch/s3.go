@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+//
+// -----------------------------------------------------------------------------
+// S3 OUTPUT
+// -----------------------------------------------------------------------------
+
+// uploadS3 puts b at an s3://bucket/key URL. Region and credentials come
+// from the standard AWS SDK environment (AWS_REGION, AWS_PROFILE, etc.).
+func uploadS3(url string, b []byte) error {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(url, "s3://"), "/")
+	if !ok || bucket == "" || key == "" {
+		return fmt.Errorf("invalid S3 URL %q (want s3://bucket/key)", url)
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.NewFromConfig(cfg).PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String(contentType(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", url, err)
+	}
+
+	logInfo("Uploaded", url)
+	return nil
+}
+
+func contentType(key string) string {
+	switch {
+	case strings.HasSuffix(key, ".json"):
+		return "application/json"
+	case strings.HasSuffix(key, ".gz"):
+		return "application/gzip"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// ZIP RECOVERY
+// -----------------------------------------------------------------------------
+
+// A download can arrive with a damaged central directory (the index at the
+// end of the file) while the entries before it are intact. archive/zip only
+// reads the central directory, so such a ZIP is salvaged by walking the local
+// file headers instead and re-packing whatever decodes cleanly.
+
+const localHeaderSig = "PK\x03\x04"
+
+// openZipBytes opens an in-memory ZIP, falling back to salvageZip when the
+// central directory is unreadable.
+func openZipBytes(b []byte) (*zip.Reader, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err == nil {
+		return zr, nil
+	}
+	return salvageWithWarning(b, err)
+}
+
+// openZipFile is openZipBytes for a ZIP on disk. The intact case keeps
+// zip.OpenReader's streaming reads; only a salvage loads the file into memory.
+func openZipFile(path string) (*zip.Reader, func(), error) {
+	rc, err := zip.OpenReader(path)
+	if err == nil {
+		return &rc.Reader, func() { rc.Close() }, nil
+	}
+
+	b, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, nil, err
+	}
+	zr, err := salvageWithWarning(b, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zr, func() {}, nil
+}
+
+// salvageWithWarning is salvageZip plus the warning listing what survived.
+func salvageWithWarning(b []byte, cause error) (*zip.Reader, error) {
+	zr, err := salvageZip(b, cause)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(zr.File))
+	for i, f := range zr.File {
+		names[i] = f.Name
+	}
+	logWarn(fmt.Sprintf("ZIP central directory unreadable (%v); recovered %d entries from local headers: %s.",
+		cause, len(names), strings.Join(names, ", ")))
+	return zr, nil
+}
+
+// salvageZip rebuilds a ZIP from the local file headers in b. Entries that
+// don't decompress or fail their CRC are dropped; the result is only
+// accepted if it still contains the airport table (see baseTable). cause is the original error,
+// returned when nothing usable is found.
+func salvageZip(b []byte, cause error) (*zip.Reader, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	hasBase := false
+
+	for off := 0; ; {
+		i := bytes.Index(b[off:], []byte(localHeaderSig))
+		if i < 0 {
+			break
+		}
+		off += i
+
+		name, data, next, ok := readLocalEntry(b, off)
+		if !ok {
+			off += len(localHeaderSig)
+			continue
+		}
+		off = next
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return nil, cause
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, cause
+		}
+		hasBase = hasBase || strings.EqualFold(name, baseTable())
+	}
+
+	if !hasBase {
+		return nil, cause
+	}
+	if err := zw.Close(); err != nil {
+		return nil, cause
+	}
+	return zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// readLocalEntry decodes the entry whose local file header starts at off,
+// returning its name, uncompressed contents, and the offset just past it.
+// Stored and deflated entries are supported. When the header defers sizes to
+// a data descriptor, a deflated entry is read until its stream ends and the
+// CRC check is skipped.
+func readLocalEntry(b []byte, off int) (name string, data []byte, next int, ok bool) {
+	const headerLen = 30
+	if len(b)-off < headerLen {
+		return "", nil, 0, false
+	}
+	h := b[off : off+headerLen]
+	flags := binary.LittleEndian.Uint16(h[6:])
+	method := binary.LittleEndian.Uint16(h[8:])
+	crc := binary.LittleEndian.Uint32(h[14:])
+	csize := int(binary.LittleEndian.Uint32(h[18:]))
+	nameLen := int(binary.LittleEndian.Uint16(h[26:]))
+	extraLen := int(binary.LittleEndian.Uint16(h[28:]))
+
+	start := off + headerLen + nameLen + extraLen
+	if start > len(b) {
+		return "", nil, 0, false
+	}
+	name = string(b[off+headerLen : off+headerLen+nameLen])
+	if name == "" || strings.HasSuffix(name, "/") {
+		return "", nil, 0, false
+	}
+
+	deferred := flags&0x8 != 0
+	if deferred {
+		if method != zip.Deflate {
+			return "", nil, 0, false
+		}
+		// The deflate stream is self-terminating; count what it consumed.
+		src := &countingReader{r: bytes.NewReader(b[start:])}
+		data, err := io.ReadAll(flate.NewReader(src))
+		if err != nil {
+			return "", nil, 0, false
+		}
+		return name, data, start + src.n, true
+	}
+
+	if start+csize > len(b) {
+		return "", nil, 0, false
+	}
+	raw := b[start : start+csize]
+	switch method {
+	case zip.Store:
+		data = raw
+	case zip.Deflate:
+		var err error
+		data, err = io.ReadAll(flate.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			return "", nil, 0, false
+		}
+	default:
+		return "", nil, 0, false
+	}
+	if crc32.ChecksumIEEE(data) != crc {
+		return "", nil, 0, false
+	}
+	return name, data, start + csize, true
+}
+
+// countingReader counts the bytes read through it. It implements
+// io.ByteReader so flate doesn't wrap it in a buffer and over-read.
+type countingReader struct {
+	r *bytes.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//
+// -----------------------------------------------------------------------------
+// SERVER MODE
+// -----------------------------------------------------------------------------
+
+// dataset is one immutable snapshot of the -out file as served over HTTP.
+// gzipped is body compressed once at load, for clients that accept gzip.
+type dataset struct {
+	body     []byte
+	gzipped  []byte
+	count    int
+	modified time.Time
+}
+
+// served is swapped whole on reload, so a request sees either the old or the
+// new dataset, never a mix.
+var served atomic.Pointer[dataset]
+
+// loadDataset reads the -out file into a new snapshot.
+func loadDataset(path string) (*dataset, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Counting top-level elements works for both the json and overlay formats.
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	gz, _, err := compressBytes(b, "gzip")
+	if err != nil {
+		return nil, err
+	}
+	return &dataset{body: b, gzipped: gz, count: len(items), modified: st.ModTime()}, nil
+}
+
+// reloadDataset swaps in the freshly written -out file. On failure the
+// previous dataset keeps being served.
+func reloadDataset() {
+	next, err := loadDataset(*outPath)
+	if err != nil {
+		logWarn("Reload failed; still serving the previous dataset:", err)
+		return
+	}
+
+	prev := served.Swap(next)
+	if prev == nil {
+		logInfo(fmt.Sprintf("Serving %d airports.", next.count))
+		return
+	}
+	logInfo(fmt.Sprintf("Reloaded dataset: %d -> %d airports.", prev.count, next.count))
+}
+
+// serveDataset serves the in-memory snapshot, or 503 before the first build.
+// Clients that accept gzip get the pre-compressed copy.
+func serveDataset(w http.ResponseWriter, r *http.Request) {
+	d := served.Load()
+	if d == nil {
+		http.Error(w, "dataset not built yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	body := d.body
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		body = d.gzipped
+	}
+	http.ServeContent(w, r, filepath.Base(*outPath), d.modified, bytes.NewReader(body))
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+// with a nonzero q-value, by name or else through "*".
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(h, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64) // malformed counts as q=0
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip":
+				gzipQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// runServer serves the dataset from memory and the rest of the -out
+// directory (the web UI) from disk. With -watch, rebuilds run in the
+// background and each new cycle is swapped in without a restart.
+func runServer() error {
+	if strings.Contains(*outPath, "://") {
+		return errors.New("-serve needs a local -out path")
+	}
+	// loadDataset reads a JSON array; kml and csv files don't parse as one.
+	if *format != "json" && *format != "overlay" {
+		return fmt.Errorf("-serve needs -format json or overlay, not %s", *format)
+	}
+
+	reloadDataset()
+	if served.Load() == nil && *watch == 0 {
+		return fmt.Errorf("no dataset at %s; run without -serve first or add -watch", *outPath)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+filepath.Base(*outPath), serveDataset)
+	mux.Handle("/", http.FileServer(http.Dir(filepath.Dir(*outPath))))
+
+	errc := make(chan error, 1)
+	if *watch > 0 {
+		go func() { errc <- runWatch(reloadDataset) }()
+	}
+	go func() {
+		logInfo("Listening on", *serve)
+		errc <- http.ListenAndServe(*serve, logRequests(requestLogger(), mux))
+	}()
+	return <-errc
+}
+
+// requestLogger logs at the same levels as the rest of the tool: -quiet keeps
+// only warnings (server errors), -verbose adds debug output.
+func requestLogger() *slog.Logger {
+	level := slog.LevelInfo
+	switch {
+	case *quiet:
+		level = slog.LevelWarn
+	case *verbose:
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+// logRequests logs each request's method, path, query, status, size, and
+// latency once it has been served. 5xx responses are logged as warnings.
+func logRequests(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelWarn
+		}
+		log.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"latency", time.Since(start),
+		)
+	})
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"maps"
+	"slices"
+)
+
+//
+// -----------------------------------------------------------------------------
+// STATE NAMES
+// -----------------------------------------------------------------------------
+
+// Names of U.S. states and territories by postal code, for -state-names.
+var stateNames = map[string]string{
+	"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas",
+	"CA": "California", "CO": "Colorado", "CT": "Connecticut", "DE": "Delaware",
+	"DC": "District of Columbia", "FL": "Florida", "GA": "Georgia", "HI": "Hawaii",
+	"ID": "Idaho", "IL": "Illinois", "IN": "Indiana", "IA": "Iowa",
+	"KS": "Kansas", "KY": "Kentucky", "LA": "Louisiana", "ME": "Maine",
+	"MD": "Maryland", "MA": "Massachusetts", "MI": "Michigan", "MN": "Minnesota",
+	"MS": "Mississippi", "MO": "Missouri", "MT": "Montana", "NE": "Nebraska",
+	"NV": "Nevada", "NH": "New Hampshire", "NJ": "New Jersey", "NM": "New Mexico",
+	"NY": "New York", "NC": "North Carolina", "ND": "North Dakota", "OH": "Ohio",
+	"OK": "Oklahoma", "OR": "Oregon", "PA": "Pennsylvania", "RI": "Rhode Island",
+	"SC": "South Carolina", "SD": "South Dakota", "TN": "Tennessee", "TX": "Texas",
+	"UT": "Utah", "VT": "Vermont", "VA": "Virginia", "WA": "Washington",
+	"WV": "West Virginia", "WI": "Wisconsin", "WY": "Wyoming",
+	"PR": "Puerto Rico", "VI": "U.S. Virgin Islands", "GU": "Guam", "AS": "American Samoa",
+	"MP": "Northern Mariana Islands",
+}
+
+// stateName returns the full name for a state code, or the code itself when
+// it isn't in the table.
+func stateName(code string) string {
+	if name, ok := stateNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// addStateNames sets StateName on every airport with a state.
+func addStateNames(airports []Airport) {
+	for i := range airports {
+		if airports[i].State != "" {
+			airports[i].StateName = stateName(airports[i].State)
+		}
+	}
+}
+
+//
+// -----------------------------------------------------------------------------
+// SPLIT BY STATE
+// -----------------------------------------------------------------------------
+
+// StateFile is one entry of states.json, the index of -split-by-state. URL
+// is relative to the index, so a static site can fetch it as-is.
+type StateFile struct {
+	State string `json:"state"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	URL   string `json:"url"`
+}
+
+// writeStateSplit writes states/<ST>.json for every state with airports,
+// then states.json listing them in state order. Airports without a state are
+// only in the full dataset.
+func writeStateSplit(airports []Airport) error {
+	byState := map[string][]Airport{}
+	for _, ap := range airports {
+		if ap.State != "" {
+			byState[ap.State] = append(byState[ap.State], ap)
+		}
+	}
+
+	index := []StateFile{}
+	for _, st := range slices.Sorted(maps.Keys(byState)) {
+		url := "states/" + st + ".json"
+		if err := writeJSON(siblingPath(url), projectAirports(byState[st])); err != nil {
+			return err
+		}
+		index = append(index, StateFile{State: st, Name: stateName(st), Count: len(byState[st]), URL: url})
+	}
+	return writeJSON(siblingPath("states.json"), index)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//
+// -----------------------------------------------------------------------------
+// STATUS FILE
+// -----------------------------------------------------------------------------
+
+// Status is the -status progress file. Phase is one of downloading, parsing,
+// enriching, writing, then done or failed; Detail says which cycle, file, or
+// how many airports, or carries the error on failure.
+type Status struct {
+	Phase   string    `json:"phase"`
+	Detail  string    `json:"detail,omitempty"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+}
+
+var runStatus Status
+
+// startStatus begins a new run in the status file.
+func startStatus() {
+	runStatus = Status{Started: time.Now().UTC()}
+}
+
+// setStatus records the current phase in status.json. The file is advisory,
+// so a failed write only warns.
+func setStatus(phase, detail string) {
+	if !*writeStatus {
+		return
+	}
+
+	runStatus.Phase = phase
+	runStatus.Detail = detail
+	runStatus.Updated = time.Now().UTC()
+
+	b, err := json.MarshalIndent(runStatus, "", "  ")
+	if err == nil {
+		err = writeFile(siblingPath("status.json"), b)
+	}
+	if err != nil {
+		logWarn("Cannot write status.json:", err)
+	}
+}
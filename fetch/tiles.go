@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// POINT TILES
+// -----------------------------------------------------------------------------
+
+// Web Mercator can't represent the poles; latitudes are clamped to its limit.
+const maxMercatorLat = 85.05112878
+
+// tileXY returns the Web Mercator (slippy map) tile containing lat, lon at
+// zoom z.
+func tileXY(lat, lon float64, z int) (x, y int) {
+	n := float64(int(1) << z)
+	lat = max(-maxMercatorLat, min(maxMercatorLat, lat)) * math.Pi / 180
+
+	x = int((lon + 180) / 360 * n)
+	y = int((1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n)
+	last := int(n) - 1
+	return max(0, min(last, x)), max(0, min(last, y))
+}
+
+// writeTiles writes one GeoJSON FeatureCollection per non-empty tile, as
+// dir/z/x/y.geojson for every zoom from 0 to maxZoom. Each tile holds every
+// airport inside it; there is no clustering or simplification, which the
+// point count doesn't need.
+func writeTiles(dir string, airports []Airport, maxZoom int) error {
+	features := make([]geoFeature, len(airports))
+	for i, ap := range airports {
+		features[i] = toGeoFeature(ap)
+	}
+
+	count := 0
+	for z := 0; z <= maxZoom; z++ {
+		tiles := map[[2]int][]geoFeature{}
+		for i, ap := range airports {
+			x, y := tileXY(ap.Lat, ap.Lon, z)
+			tiles[[2]int{x, y}] = append(tiles[[2]int{x, y}], features[i])
+		}
+
+		for xy, fs := range tiles {
+			b, err := json.Marshal(geoCollection{Type: "FeatureCollection", Features: fs})
+			if err != nil {
+				return err
+			}
+			name := fmt.Sprintf("%d/%d/%d.geojson", z, xy[0], xy[1])
+			if err := writeFile(tilePath(dir, name), b); err != nil {
+				return err
+			}
+		}
+		count += len(tiles)
+	}
+
+	logInfo(fmt.Sprintf("Wrote %d tiles (zoom 0-%d) under %s.", count, maxZoom, dir))
+	return nil
+}
+
+// tilePath joins a z/x/y name onto a local directory or s3:// prefix.
+func tilePath(dir, name string) string {
+	if strings.Contains(dir, "://") {
+		return strings.TrimSuffix(dir, "/") + "/" + name
+	}
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
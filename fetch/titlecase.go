@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+//
+// -----------------------------------------------------------------------------
+// TITLE CASE
+// -----------------------------------------------------------------------------
+
+// Words kept in capitals by -title-case: compass directions, Roman numerals,
+// and military and corporate abbreviations that read wrong as words.
+var upperWords = map[string]bool{
+	"N": true, "S": true, "E": true, "W": true,
+	"NE": true, "NW": true, "SE": true, "SW": true,
+	"II": true, "III": true, "IV": true,
+	"AFB": true, "AAF": true, "AHP": true, "ANGB": true, "ARB": true,
+	"JRB": true, "MCAS": true, "NAF": true, "NALF": true, "NAS": true,
+	"NAWS": true, "NOLF": true, "USAF": true, "USMC": true, "USN": true,
+	"LLC": true,
+}
+
+// Short words lowercased by -title-case unless they start the name.
+var minorWords = map[string]bool{
+	"A": true, "AN": true, "AND": true, "AT": true, "DE": true,
+	"IN": true, "OF": true, "ON": true, "THE": true,
+}
+
+// addTitleCase rewrites Name and City with titleCase, for -title-case.
+func addTitleCase(airports []Airport) {
+	for i := range airports {
+		airports[i].Name = titleCase(airports[i].Name)
+		airports[i].City = titleCase(airports[i].City)
+	}
+}
+
+// titleCase converts an all-caps FAA name to title case, word by word (words
+// are separated by spaces):
+//
+//   - upperWords stay capitals and minorWords are lowercased, except as the
+//     first word; words containing a digit (I-80, 4FL) are kept as-is
+//   - otherwise the letter after a hyphen, slash, parenthesis, or period is
+//     capitalized (Wilkes-Barre/Wyoming), as is the one after a leading
+//     "MC" (McKinley) or "O'"/"D'" (O'Hare); other letters are lowercased,
+//     so "JOHN'S" becomes "John's"
+//
+// Values that already contain lowercase letters are returned unchanged.
+func titleCase(s string) string {
+	if strings.ContainsFunc(s, unicode.IsLower) {
+		return s
+	}
+
+	words := strings.Split(s, " ")
+	for i, w := range words {
+		bare := strings.Trim(w, "(),.")
+		switch {
+		case strings.ContainsFunc(w, unicode.IsDigit), upperWords[bare]:
+			continue
+		case i > 0 && minorWords[w]:
+			words[i] = strings.ToLower(w)
+		default:
+			words[i] = titleWord(w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// titleWord capitalizes one word per titleCase. The "MC" rule never applies
+// to an upperWords abbreviation such as MCAS.
+func titleWord(w string) string {
+	abbrev := upperWords[strings.Trim(w, "(),.")]
+	r := []rune(strings.ToLower(w))
+	upper := true
+	for i, c := range r {
+		if upper && unicode.IsLetter(c) {
+			r[i] = unicode.ToUpper(c)
+			upper = false
+			continue
+		}
+		switch {
+		case strings.ContainsRune("-/(.", c):
+			upper = true
+		case c == '\'' && i == 1 && (r[0] == 'O' || r[0] == 'D'):
+			upper = true
+		case !abbrev && i == 1 && r[0] == 'M' && c == 'c' && len(r) > 3:
+			upper = true
+		}
+	}
+	return string(r)
+}
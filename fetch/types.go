@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+//
+// -----------------------------------------------------------------------------
+// TYPE DEFINITIONS
+// -----------------------------------------------------------------------------
+
+// jsonField is one serialized struct field, as encoding/json sees it.
+type jsonField struct {
+	name     string
+	optional bool
+	typ      reflect.Type
+}
+
+// jsonFields lists t's exported fields by their JSON keys.
+func jsonFields(t reflect.Type) []jsonField {
+	var out []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, jsonField{name, strings.Contains(opts, "omitempty"), f.Type})
+	}
+	return out
+}
+
+// emitTypes prints the output types in the -emit-types language. The
+// definitions are derived from Airport by reflection, so they follow the
+// struct as fields are added.
+func emitTypes(lang string) error {
+	switch lang {
+	case "ts":
+		fmt.Print(typeScript())
+		return nil
+	case "json-schema":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(jsonSchema())
+	default:
+		return fmt.Errorf("invalid -emit-types %q (want ts or json-schema)", lang)
+	}
+}
+
+// outputStructs are the named types in the dataset, dependencies first.
+var outputStructs = []reflect.Type{reflect.TypeOf(Fuel{}), reflect.TypeOf(Airport{})}
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func typeScript() string {
+	var b strings.Builder
+	b.WriteString("// Generated by `fetch -emit-types ts`; do not edit.\n")
+	for _, t := range outputStructs {
+		fmt.Fprintf(&b, "\nexport interface %s {\n", t.Name())
+		for _, f := range jsonFields(t) {
+			name := f.name
+			if !tsIdentifier.MatchString(name) {
+				name = fmt.Sprintf("%q", name)
+			}
+			if f.optional {
+				name += "?"
+			}
+			fmt.Fprintf(&b, "  %s: %s;\n", name, tsType(f.typ))
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func tsType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Float64:
+		return "number"
+	case reflect.Struct:
+		return t.Name()
+	case reflect.Pointer:
+		return tsType(t.Elem())
+	}
+	return "unknown"
+}
+
+// jsonSchema describes the dataset file: an array of Airport.
+func jsonSchema() map[string]any {
+	defs := map[string]any{}
+	for _, t := range outputStructs {
+		props := map[string]any{}
+		var required []string
+		for _, f := range jsonFields(t) {
+			props[f.name] = schemaType(f.typ)
+			if !f.optional {
+				required = append(required, f.name)
+			}
+		}
+		defs[t.Name()] = map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "airports.json",
+		"type":    "array",
+		"items":   map[string]any{"$ref": "#/$defs/Airport"},
+		"$defs":   defs,
+	}
+}
+
+func schemaType(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Struct:
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Pointer:
+		return schemaType(t.Elem())
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//
+// -----------------------------------------------------------------------------
+// WATCH MODE
+// -----------------------------------------------------------------------------
+
+// Shortest accepted -watch interval; NASR publishes every 28 days, so polling
+// faster only hammers the FAA server.
+const minWatchInterval = time.Minute
+
+// runWatch rebuilds the dataset every -watch interval until the process is
+// stopped. A failed run (FAA unreachable, bad ZIP) is logged and retried at
+// the next interval; the last good dataset stays in place. onBuild, if not
+// nil, is called after each run that built a new cycle.
+func runWatch(onBuild func()) error {
+	if *watch < minWatchInterval {
+		return fmt.Errorf("-watch must be at least %s", minWatchInterval)
+	}
+
+	var built time.Time
+	for run := 1; ; run++ {
+		logInfo(fmt.Sprintf("Watch run %d at %s", run, time.Now().UTC().Format(time.RFC3339)))
+
+		cycle, err := updateFrom(built)
+		if err != nil {
+			logWarn("Update failed; keeping the previous dataset:", err)
+		} else if !cycle.Equal(built) {
+			built = cycle
+			if onBuild != nil {
+				onBuild()
+			}
+		}
+
+		logInfo("Next check in", *watch)
+		time.Sleep(*watch)
+	}
+}